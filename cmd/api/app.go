@@ -7,27 +7,37 @@ import (
 
 	"github.com/travboz/greenlightv3/internal/data"
 	"github.com/travboz/greenlightv3/internal/mailer"
+	"github.com/travboz/greenlightv3/internal/storage"
+	"github.com/travboz/greenlightv3/internal/webhook"
 )
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
 // and middleware. At the moment this only contains a copy of the config struct and a
 // logger, but it will grow to include a lot more as our build progresses.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	bwg    sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Store
+	similar *similarCache
+	events  *movieEventHub
+	webhook *webhook.Client
+	bwg     sync.WaitGroup
 	// Include a sync.WaitGroup in the application struct. The zero-value for a
 	// sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0,
 	// so we don't need to do anything else to initialize it before we can use it.
 }
 
-func NewApplication(cfg config, logger *slog.Logger, db *sql.DB) *application {
+func NewApplication(cfg config, logger *slog.Logger, db *sql.DB, store storage.Store) *application {
 	return &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		similar: newSimilarCache(),
+		events:  newMovieEventHub(),
+		webhook: webhook.New(cfg.webhooks.timeout, "greenlight/"+version),
 	}
 }
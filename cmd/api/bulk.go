@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define limits for bulk imports. Imports can be much larger than the request bodies
+// we accept elsewhere, so they get their own body size limit and read deadline.
+const (
+	maxBulkBytes    = 32 << 20
+	maxBulkMovies   = 10_000
+	bulkReadTimeout = time.Minute
+)
+
+// Define the media types used for bulk imports and exports.
+const (
+	ndjsonMediaType = "application/x-ndjson"
+	csvMediaType    = "text/csv"
+)
+
+// A bulkRow is a single movie from a bulk import, along with any errors found while
+// decoding or validating it. Rows are numbered from 1.
+type bulkRow struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+	movie  *data.Movie
+}
+
+// bulkMovie is the shape of a movie in a JSON or NDJSON import. The id and version
+// fields are accepted so that the output of the export endpoint can be imported again,
+// but they are ignored.
+type bulkMovie struct {
+	data.MovieSnapshot
+	ID      json.RawMessage `json:"id"`
+	Version json.RawMessage `json:"version"`
+}
+
+func (app *application) bulkCreateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// By default the import is atomic, so if any row is invalid nothing is inserted.
+	// With ?atomic=false the valid rows are inserted and the invalid ones reported.
+	atomic := true
+	if b := app.readBool(r.URL.Query(), "atomic", v); b != nil {
+		atomic = *b
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "application/json"
+	}
+
+	// Large imports can take longer to upload than our server's read timeout allows,
+	// so extend the deadline for this request.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(bulkReadTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBytes)
+
+	// Fetch the slugs of the genres in the taxonomy once, for validating every movie.
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var rows []*bulkRow
+
+	switch mediaType {
+	case "application/json":
+		rows, err = readJSONMovies(r.Body, knownGenres)
+	case ndjsonMediaType:
+		rows, err = readNDJSONMovies(r.Body, knownGenres)
+	case csvMediaType:
+		rows, err = readCSVMovies(r.Body, knownGenres)
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	var movies []*data.Movie
+	invalid := []*bulkRow{}
+
+	for _, row := range rows {
+		if row.Errors != nil {
+			invalid = append(invalid, row)
+			continue
+		}
+
+		movies = append(movies, row.movie)
+	}
+
+	if len(movies) == 0 || (atomic && len(invalid) > 0) {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"rows": invalid})
+		return
+	}
+
+	// The import is saved in a single transaction, so if any movie has an external ID
+	// which is already in use (by an existing movie, or another movie in the import)
+	// nothing is inserted.
+	err = app.models.Movies.InsertMany(movies, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateImdbID):
+			v.AddError("imdb_id", "the import contains a movie with an IMDb ID which is already in use")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTmdbID):
+			v.AddError("tmdb_id", "the import contains a movie with a TMDB ID which is already in use")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	result := envelope{
+		"received": len(rows),
+		"inserted": len(movies),
+		"ids":      ids,
+		"errors":   invalid,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"import": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readJSONMovies reads a JSON array of movies. A body which isn't a well-formed JSON
+// array is an error, but problems with the individual movies are recorded on their
+// rows.
+func readJSONMovies(body io.Reader, knownGenres []string) ([]*bulkRow, error) {
+	dec := json.NewDecoder(body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonStreamError(err)
+	}
+
+	if tok != json.Delim('[') {
+		return nil, errors.New("body must contain a JSON array of movies")
+	}
+
+	var rows []*bulkRow
+
+	for dec.More() {
+		if len(rows) == maxBulkMovies {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxBulkMovies)
+		}
+
+		var raw json.RawMessage
+
+		err = dec.Decode(&raw)
+		if err != nil {
+			return nil, jsonStreamError(err)
+		}
+
+		rows = append(rows, decodeBulkMovie(len(rows)+1, raw, knownGenres))
+	}
+
+	// Consume the closing bracket, and make sure that nothing follows it.
+	_, err = dec.Token()
+	if err != nil {
+		return nil, jsonStreamError(err)
+	}
+
+	if _, err = dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("body must only contain a single JSON array")
+	}
+
+	return rows, nil
+}
+
+// readNDJSONMovies reads newline-delimited JSON, with one movie per line. Blank lines
+// are skipped. Each line stands on its own, so a badly-formed line is recorded as an
+// error on its row.
+func readNDJSONMovies(body io.Reader, knownGenres []string) ([]*bulkRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	var rows []*bulkRow
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if len(rows) == maxBulkMovies {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxBulkMovies)
+		}
+
+		rows = append(rows, decodeBulkMovie(len(rows)+1, line, knownGenres))
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, errors.New("body contains a line longer than 1048576 bytes")
+		}
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// decodeBulkMovie decodes and validates a single movie from a JSON or NDJSON import.
+func decodeBulkMovie(n int, raw []byte, knownGenres []string) *bulkRow {
+	v := validator.New()
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	var input bulkMovie
+
+	err := dec.Decode(&input)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+			v.AddError("movie", "contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			v.AddError(unmarshalTypeError.Field, "has the wrong JSON type")
+		case errors.As(err, &unmarshalTypeError):
+			v.AddError("movie", "must be a JSON object")
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			v.AddError("runtime", `must be in the format "<runtime> mins"`)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			v.AddError(strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "is not a movie field")
+		default:
+			v.AddError("movie", err.Error())
+		}
+
+		return &bulkRow{Row: n, Errors: v.Errors}
+	}
+
+	if dec.More() {
+		v.AddError("movie", "must only contain a single JSON object")
+		return &bulkRow{Row: n, Errors: v.Errors}
+	}
+
+	movie := &data.Movie{}
+	input.MovieSnapshot.ApplyTo(movie)
+
+	if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
+		return &bulkRow{Row: n, Errors: v.Errors}
+	}
+
+	return &bulkRow{Row: n, movie: movie}
+}
+
+// jsonStreamError converts an error from decoding a JSON import into a plain-english
+// message, in the same way as readJSON().
+func jsonStreamError(err error) error {
+	var syntaxError *json.SyntaxError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return err
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	default:
+		return err
+	}
+}
+
+// The CSV columns for bulk imports and exports. Genres are comma-separated within their
+// column, the runtime is a number of minutes, and the releases are a JSON array in the
+// same format as the JSON representation. Empty imdb_id, tmdb_id and releases cells
+// mean that they're unknown.
+var movieCSVColumns = []string{"id", "title", "year", "runtime", "genres", "imdb_id", "tmdb_id", "releases", "version"}
+
+// readCSVMovies reads a CSV import. The first record must be a header naming the
+// columns; title, year, runtime and genres are required, imdb_id, tmdb_id and releases
+// are optional, and id and version are ignored if present.
+func readCSVMovies(body io.Reader, knownGenres []string) ([]*bulkRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, csvStreamError(err)
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !validator.PermittedValue(name, movieCSVColumns...) {
+			return nil, fmt.Errorf("body contains unknown CSV column %q", name)
+		}
+
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("body is missing the CSV column %q", name)
+		}
+	}
+
+	var rows []*bulkRow
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, csvStreamError(err)
+		}
+
+		if len(rows) == maxBulkMovies {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxBulkMovies)
+		}
+
+		rows = append(rows, decodeCSVMovie(len(rows)+1, record, columns, knownGenres))
+	}
+
+	return rows, nil
+}
+
+// decodeCSVMovie decodes and validates a single movie from a CSV import.
+func decodeCSVMovie(n int, record []string, columns map[string]int, knownGenres []string) *bulkRow {
+	v := validator.New()
+
+	movie := &data.Movie{
+		Title: record[columns["title"]],
+	}
+
+	year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+	if err != nil {
+		v.AddError("year", "must be an integer")
+	}
+	movie.Year = int32(year)
+
+	// Accept the runtime either as a number of minutes, or in the "<runtime> mins"
+	// format used by the JSON representation.
+	runtime, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(record[columns["runtime"]]), " mins"), 10, 32)
+	if err != nil {
+		v.AddError("runtime", "must be an integer number of minutes")
+	}
+	movie.Runtime = data.Runtime(runtime)
+
+	for _, genre := range strings.Split(record[columns["genres"]], ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			movie.Genres = append(movie.Genres, genre)
+		}
+	}
+
+	if i, ok := columns["imdb_id"]; ok {
+		movie.ImdbID = strings.TrimSpace(record[i])
+	}
+
+	if i, ok := columns["tmdb_id"]; ok {
+		if cell := strings.TrimSpace(record[i]); cell != "" {
+			movie.TmdbID, err = strconv.ParseInt(cell, 10, 64)
+			if err != nil {
+				v.AddError("tmdb_id", "must be an integer")
+			}
+		}
+	}
+
+	if i, ok := columns["releases"]; ok {
+		if cell := strings.TrimSpace(record[i]); cell != "" {
+			dec := json.NewDecoder(strings.NewReader(cell))
+			dec.DisallowUnknownFields()
+
+			err = dec.Decode(&movie.Releases)
+			if err != nil || dec.More() {
+				v.AddError("releases", "must be a JSON array of releases")
+			}
+		}
+	}
+
+	// AddError() keeps the first error for each key, so any parse errors above take
+	// precedence over the validation errors for the same fields.
+	if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
+		return &bulkRow{Row: n, Errors: v.Errors}
+	}
+
+	return &bulkRow{Row: n, movie: movie}
+}
+
+func csvStreamError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	var parseError *csv.ParseError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return err
+	case errors.As(err, &parseError):
+		return fmt.Errorf("body contains badly-formed CSV (line %d): %v", parseError.Line, parseError.Err)
+	default:
+		return err
+	}
+}
+
+// movieCSVRecord returns the CSV record for a movie in an export, with its fields in
+// the same order as movieCSVColumns.
+func movieCSVRecord(movie *data.Movie) ([]string, error) {
+	var tmdbID, releases string
+
+	if movie.TmdbID != 0 {
+		tmdbID = strconv.FormatInt(movie.TmdbID, 10)
+	}
+
+	if len(movie.Releases) > 0 {
+		js, err := json.Marshal(movie.Releases)
+		if err != nil {
+			return nil, err
+		}
+
+		releases = string(js)
+	}
+
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		strings.Join(movie.Genres, ","),
+		movie.ImdbID,
+		tmdbID,
+		releases,
+		strconv.Itoa(int(movie.Version)),
+	}, nil
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", "ndjson")
+
+	v.Check(validator.PermittedValue(format, "ndjson", "csv"), "format", "must be ndjson or csv")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The export is streamed, so it can take longer than our server's write timeout.
+	// Remove the deadline for this request; the export will still stop if the client
+	// goes away, because the query uses the request context.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	var (
+		cw  *csv.Writer
+		enc *json.Encoder
+	)
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", csvMediaType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		// The csv.Writer is buffered, so the header row isn't sent until the first
+		// movies are (or the export finishes).
+		cw = csv.NewWriter(w)
+		cw.Write(movieCSVColumns)
+	default:
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+
+		enc = json.NewEncoder(w)
+	}
+
+	exported := 0
+
+	err := app.models.Movies.Export(r.Context(), func(movie *data.Movie) error {
+		exported++
+
+		if cw != nil {
+			record, err := movieCSVRecord(movie)
+			if err != nil {
+				return err
+			}
+
+			return cw.Write(record)
+		}
+
+		return enc.Encode(movie)
+	})
+	if err == nil && cw != nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+
+	if err != nil {
+		// Once we've started sending the export we can't send an error response, so
+		// all we can do is log the error and stop.
+		if exported > 0 {
+			app.logError(r, err)
+			return
+		}
+
+		w.Header().Del("Content-Disposition")
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+func TestMovieCSVRoundTrip(t *testing.T) {
+	movie := &data.Movie{
+		ID:      7,
+		Title:   "The Shawshank Redemption",
+		Year:    1994,
+		Runtime: 142,
+		Genres:  []string{"drama", "crime"},
+		Version: 3,
+		ImdbID:  "tt0111161",
+		TmdbID:  278,
+		Releases: data.Releases{
+			{Country: "US", Date: "1994-09-23", Certification: "R"},
+			{Country: "GB", Date: "1995-02-17", Certification: "15"},
+		},
+	}
+
+	record, err := movieCSVRecord(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	cw := csv.NewWriter(&buf)
+	cw.Write(movieCSVColumns)
+	cw.Write(record)
+	cw.Flush()
+
+	rows, err := readCSVMovies(&buf, []string{"crime", "drama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 || rows[0].movie == nil {
+		t.Fatalf("got %+v, want a single valid row", rows)
+	}
+
+	got := rows[0].movie
+
+	// The id and version aren't imported.
+	want := *movie
+	want.ID, want.Version = 0, 0
+
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+}
+
+func TestReadCSVMoviesOptionalColumns(t *testing.T) {
+	tests := []struct {
+		name       string
+		csv        string
+		wantErrors map[string]string
+	}{
+		{
+			name: "Required columns only",
+			csv:  "title,year,runtime,genres\nHeat,1995,170,crime\n",
+		},
+		{
+			name: "Empty optional cells",
+			csv:  "title,year,runtime,genres,imdb_id,tmdb_id,releases\nHeat,1995,170,crime,,,\n",
+		},
+		{
+			name:       "Bad tmdb_id",
+			csv:        "title,year,runtime,genres,tmdb_id\nHeat,1995,170,crime,abc\n",
+			wantErrors: map[string]string{"tmdb_id": "must be an integer"},
+		},
+		{
+			name:       "Bad releases",
+			csv:        "title,year,runtime,genres,releases\nHeat,1995,170,crime,\"{\"\"country\"\":\"\"US\"\"}\"\n",
+			wantErrors: map[string]string{"releases": "must be a JSON array of releases"},
+		},
+		{
+			name:       "Invalid release",
+			csv:        "title,year,runtime,genres,releases\nHeat,1995,170,crime,\"[{\"\"country\"\":\"\"US\"\",\"\"date\"\":\"\"soon\"\"}]\"\n",
+			wantErrors: map[string]string{"releases": "release 1 must have a date in the format YYYY-MM-DD"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := readCSVMovies(strings.NewReader(tt.csv), []string{"crime"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+
+			if !reflect.DeepEqual(rows[0].Errors, tt.wantErrors) {
+				t.Errorf("got errors %v, want %v", rows[0].Errors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -24,6 +25,10 @@ type config struct {
 	smtp           smtpConfig
 	cors           corsConfig
 	jwt            jwtConfig
+	trash          trashConfig
+	storage        storageConfig
+	webhooks       webhooksConfig
+	privacyMode    bool
 	displayVersion bool
 }
 
@@ -59,6 +64,29 @@ type jwtConfig struct {
 	secret string // JWT signing secret
 }
 
+// The trashConfig struct holds the settings for purging soft-deleted movies. Movies
+// which have been in the trash for longer than retentionDays are permanently deleted by
+// a background job which runs every purgeInterval.
+type trashConfig struct {
+	retentionDays int
+	purgeInterval time.Duration
+}
+
+// The storageConfig struct holds the settings for the blob store which uploaded files,
+// like movie posters, are saved in.
+type storageConfig struct {
+	dir string
+}
+
+// The webhooksConfig struct holds the settings for delivering movie events to
+// webhooks. A background job checks for due deliveries every pollInterval, and gives
+// up on a delivery once it has failed maxAttempts times.
+type webhooksConfig struct {
+	pollInterval time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+}
+
 func NewConfig() config {
 	var cfg config
 
@@ -122,10 +150,50 @@ func NewConfig() config {
 		"JWT secret",
 	)
 
+	flag.IntVar(&cfg.trash.retentionDays, "trash-retention-days", env.GetInt("TRASH_RETENTION_DAYS", 30), "Days to keep deleted movies in the trash before purging them")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", env.GetDuration("TRASH_PURGE_INTERVAL", time.Hour), "How often to purge expired movies from the trash (e.g. 1h)")
+
+	flag.StringVar(&cfg.storage.dir, "storage-dir", env.GetString("STORAGE_DIR", "./uploads"), "Directory to store uploaded files in")
+
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", env.GetDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second), "How often to check for webhook deliveries which are due (e.g. 5s)")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", env.GetDuration("WEBHOOK_TIMEOUT", 10*time.Second), "Timeout for each attempt to deliver to a webhook")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", env.GetInt("WEBHOOK_MAX_ATTEMPTS", 10), "Attempts to make at each webhook delivery before giving up")
+
+	// When privacy mode is enabled, the token endpoints always respond in the same way
+	// regardless of whether an account exists for the given email address.
+	flag.BoolVar(&cfg.privacyMode, "privacy-mode", env.GetBool("PRIVACY_MODE", false), "Don't reveal whether an email address is registered")
+
 	// Create a new version boolean flag with the default value of false.
 	flag.BoolVar(&cfg.displayVersion, "version", env.GetBool("DISPLAY_VERSION", false), "Display version and exit")
 
 	flag.Parse()
 
+	// Reject settings which would otherwise only fail once the server is running, like
+	// the intervals which the background jobs' tickers are created with. Like a flag
+	// which can't be parsed, an invalid setting stops the application straight away.
+	err := cfg.validate()
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+
 	return cfg
 }
+
+// validate() returns an error describing the first setting which is out of range.
+func (cfg config) validate() error {
+	switch {
+	case cfg.trash.retentionDays < 0:
+		return errors.New("-trash-retention-days must not be negative")
+	case cfg.trash.purgeInterval <= 0:
+		return errors.New("-trash-purge-interval must be positive")
+	case cfg.webhooks.pollInterval <= 0:
+		return errors.New("-webhook-poll-interval must be positive")
+	case cfg.webhooks.timeout <= 0:
+		return errors.New("-webhook-timeout must be positive")
+	case cfg.webhooks.maxAttempts < 1:
+		return errors.New("-webhook-max-attempts must be at least 1")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() config {
+		var cfg config
+
+		cfg.trash.retentionDays = 30
+		cfg.trash.purgeInterval = time.Hour
+		cfg.webhooks.pollInterval = 5 * time.Second
+		cfg.webhooks.timeout = 10 * time.Second
+		cfg.webhooks.maxAttempts = 10
+
+		return cfg
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(cfg *config)
+		wantValid bool
+	}{
+		{"Defaults", func(cfg *config) {}, true},
+		{"No retention", func(cfg *config) { cfg.trash.retentionDays = 0 }, true},
+		{"Negative retention", func(cfg *config) { cfg.trash.retentionDays = -1 }, false},
+		{"Zero purge interval", func(cfg *config) { cfg.trash.purgeInterval = 0 }, false},
+		{"Negative purge interval", func(cfg *config) { cfg.trash.purgeInterval = -time.Hour }, false},
+		{"Zero poll interval", func(cfg *config) { cfg.webhooks.pollInterval = 0 }, false},
+		{"Zero webhook timeout", func(cfg *config) { cfg.webhooks.timeout = 0 }, false},
+		{"One attempt", func(cfg *config) { cfg.webhooks.maxAttempts = 1 }, true},
+		{"No attempts", func(cfg *config) { cfg.webhooks.maxAttempts = 0 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+
+			err := cfg.validate()
+
+			if (err == nil) != tt.wantValid {
+				t.Errorf("got error %v, want valid %t", err, tt.wantValid)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check that the movie exists (and isn't in the trash) before listing its credits.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credits, err := app.models.Credits.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		PersonID  int64  `json:"person_id"`
+		Role      string `json:"role"`
+		Character string `json:"character"`
+		Billing   int32  `json:"billing"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:   id,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+		Billing:   input.Billing,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Look up the person being credited, which both checks that they exist and gives
+	// us their details to include in the response.
+	credit.Person, err = app.models.People.Get(credit.PersonID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("person_id", "must refer to an existing person")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Credits.Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("person_id", "already has this credit on the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	creditID, err := app.readInt64Param(r, "credit_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Credits.Delete(id, creditID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// The sendEmail() helper sends an email using the given template and records the
+// outcome in the emails audit table. It is intended to be called from inside
+// app.background(), so rather than returning errors it logs them.
+func (app *application) sendEmail(recipient, templateFile string, templateData any) {
+	email := &data.Email{
+		Recipient: recipient,
+		Template:  templateFile,
+		Status:    data.EmailStatusPending,
+	}
+
+	// Record the email before we try to send it. If this fails we still go ahead and
+	// send the email, as a missing audit record is less harmful than a missing email.
+	err := app.models.Emails.Insert(email)
+	if err != nil {
+		app.logger.Error(err.Error(), "recipient", recipient, "template", templateFile)
+	}
+
+	attempts, sendErr := app.mailer.Send(recipient, templateFile, templateData)
+
+	email.Attempts = attempts
+	email.Status = data.EmailStatusSent
+
+	if sendErr != nil {
+		email.Status = data.EmailStatusFailed
+		email.Error = sendErr.Error()
+		app.logger.Error(sendErr.Error(), "recipient", recipient, "template", templateFile, "attempts", attempts)
+	}
+
+	// Only update the audit record if we managed to create it in the first place.
+	if email.ID != 0 {
+		err = app.models.Emails.Update(email)
+		if err != nil {
+			app.logger.Error(err.Error(), "email_id", email.ID)
+		}
+	}
+}
+
+func (app *application) listEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Recipient string
+		Template  string
+		Status    string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Recipient = app.readString(qs, "recipient", "")
+	input.Template = app.readString(qs, "template", "")
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Default to showing the most recent emails first.
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "recipient", "status", "attempts", "-id", "-created_at", "-recipient", "-status", "-attempts"}
+
+	data.ValidateEmailStatus(v, input.Status)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := app.models.Emails.GetAll(input.Recipient, input.Template, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
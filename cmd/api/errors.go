@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/travboz/greenlightv3/internal/data"
 )
 
 // The logError() method is a generic helper for logging an error message along
@@ -74,6 +76,31 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// The unsupportedMediaTypeResponse() method is used when the request body is in a
+// format that the endpoint doesn't accept.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The preconditionFailedResponse() method is used when a client sends an If-Match
+// header for a version of a resource which is no longer current.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last retrieved it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The duplicateMovieResponse() method is used when a new movie looks like a duplicate
+// of existing ones. It sends a 409 Conflict status code along with the likely
+// duplicates, so that the client can check them before retrying with ?force=true.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.Movie) {
+	message := envelope{
+		"message":    "this movie looks like a duplicate of an existing movie; to create it anyway, retry with ?force=true",
+		"duplicates": duplicates,
+	}
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -84,6 +111,18 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// The privacyAcceptedResponse() method is used by the token endpoints in privacy mode.
+// It sends the same 202 Accepted response whether or not the email address belongs to
+// a user, so that it can't be used to find out which addresses are registered.
+func (app *application) privacyAcceptedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "if an account matching this email address exists, an email will be sent to it with further instructions"
+
+	err := app.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+// The movieVersionETag() helper returns the entity tag for the version of a movie,
+// which is what If-Match preconditions are checked against. The version number is
+// bumped every time the movie itself changes. A localized movie has a different tag
+// for each locale its title could be in.
+func movieVersionETag(movie *data.Movie) string {
+	if movie.TitleLocale != "" {
+		return fmt.Sprintf(`"%d-%s"`, movie.Version, movie.TitleLocale)
+	}
+
+	return fmt.Sprintf(`"%d"`, movie.Version)
+}
+
+// The movieETag() helper returns the entity tag for the representation of a single
+// movie. The rating aggregates and the list of poster thumbnails are updated without
+// bumping the version, so they're included after the version tag, separated by a
+// colon. Rating a movie (or finishing its thumbnails) therefore changes its entity tag
+// for If-None-Match, but doesn't break an editor's If-Match.
+func movieETag(movie *data.Movie) string {
+	version := strings.Trim(movieVersionETag(movie), `"`)
+
+	return fmt.Sprintf(`"%s:%d-%.2f-%d"`, version, movie.RatingCount, movie.AverageRating, len(movie.Poster.Thumbnails))
+}
+
+// The movieVersionTags() helper rewrites the strong entity tags in an If-Match header
+// to their version part, dropping anything after the colon, so that the header can be
+// compared against movieVersionETag(). Tags which are already just a version are left
+// as they are.
+func movieVersionTags(header string) string {
+	candidates := strings.Split(header, ",")
+
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+
+		if len(candidate) >= 2 && strings.HasPrefix(candidate, `"`) && strings.HasSuffix(candidate, `"`) {
+			version, _, _ := strings.Cut(candidate[1:len(candidate)-1], ":")
+			candidate = `"` + version + `"`
+		}
+
+		candidates[i] = candidate
+	}
+
+	return strings.Join(candidates, ",")
+}
+
+// The moviesETag() helper returns a weak entity tag for a page of movies, based on the
+// ID and entity tag of each movie on the page and the total number of records. It
+// changes whenever any movie on the page changes, or movies are added to or removed
+// from the results.
+func moviesETag(movies []*data.Movie, metadata data.Metadata) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%d;", metadata.TotalRecords)
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d:%s;", movie.ID, movieETag(movie))
+	}
+
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(h.Sum(nil))[:32])
+}
+
+// The etagMatches() helper reports whether an If-Match or If-None-Match header value
+// matches the given entity tag. The header may contain a comma-separated list of tags
+// or "*", which matches any tag. If-Match uses the strong comparison (weak tags never
+// match), while If-None-Match uses the weak comparison (the W/ prefix is ignored), as
+// described in RFC 9110.
+func etagMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The checkIfNoneMatch() helper is used by GET handlers. If the request has an
+// If-None-Match header matching the current entity tag, it sends a 304 Not Modified
+// response and returns true, in which case the handler should return straight away.
+func (app *application) checkIfNoneMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// The checkIfMatch() helper is used by handlers which change a resource. If the request
+// has an If-Match header which doesn't match the current entity tag, it sends a 412
+// Precondition Failed response and returns false, in which case the handler should
+// return straight away.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, etag, false) {
+		return true
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{name: "exact", header: `"3"`, etag: `"3"`, want: true},
+		{name: "different", header: `"2"`, etag: `"3"`, want: false},
+		{name: "list", header: `"1", "2" ,"3"`, etag: `"3"`, want: true},
+		{name: "wildcard", header: `*`, etag: `"3"`, want: true},
+		{name: "weak header strong comparison", header: `W/"3"`, etag: `"3"`, want: false},
+		{name: "weak header weak comparison", header: `W/"3"`, etag: `"3"`, weak: true, want: true},
+		{name: "weak etag strong comparison", header: `W/"abc"`, etag: `W/"abc"`, want: false},
+		{name: "weak etag weak comparison", header: `"abc"`, etag: `W/"abc"`, weak: true, want: true},
+		{name: "unquoted", header: `3`, etag: `"3"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t, want %t", tt.header, tt.etag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovieETag(t *testing.T) {
+	movie := &data.Movie{Version: 3, RatingCount: 2, AverageRating: 4.5}
+
+	etag := movieETag(movie)
+
+	// Rating the movie changes the entity tag...
+	rated := *movie
+	rated.RatingCount, rated.AverageRating = 3, 4
+
+	if movieETag(&rated) == etag {
+		t.Errorf("got the same entity tag %s after the movie was rated", etag)
+	}
+
+	// ...but the old tag still satisfies an If-Match precondition, as the version
+	// hasn't changed.
+	tests := []struct {
+		name   string
+		header string
+		movie  *data.Movie
+		want   bool
+	}{
+		{name: "same version", header: etag, movie: &rated, want: true},
+		{name: "version only", header: `"3"`, movie: &rated, want: true},
+		{name: "list", header: `"2:0-0.00-0", ` + etag, movie: &rated, want: true},
+		{name: "new version", header: etag, movie: &data.Movie{Version: 4}, want: false},
+		{name: "localized", header: movieETag(&data.Movie{Version: 3, TitleLocale: "fr"}), movie: &data.Movie{Version: 3, TitleLocale: "fr"}, want: true},
+		{name: "other locale", header: movieETag(&data.Movie{Version: 3, TitleLocale: "fr"}), movie: &rated, want: false},
+		{name: "weak", header: "W/" + etag, movie: &rated, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(movieVersionTags(tt.header), movieVersionETag(tt.movie), false); got != tt.want {
+				t.Errorf("If-Match %s against %s = %t, want %t", tt.header, movieVersionETag(tt.movie), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define the settings for the movie events stream.
+const (
+	// maxEventReplay is the most missed events that are replayed to a client which
+	// resumes the stream. Clients which have missed more are told to resync instead.
+	maxEventReplay = 1000
+
+	// eventBufferSize is how many events can queue up for a client before it's
+	// disconnected for being too slow.
+	eventBufferSize = 64
+
+	// eventHeartbeatInterval is how often a comment is sent on an idle stream, so
+	// that proxies don't close it and so that we notice when the client has gone.
+	eventHeartbeatInterval = 15 * time.Second
+
+	// eventWriteTimeout replaces the server's write timeout for each write to the
+	// stream, as the stream as a whole stays open for much longer.
+	eventWriteTimeout = 10 * time.Second
+
+	// eventRetry is how long clients should wait before reconnecting, in
+	// milliseconds.
+	eventRetry = 5000
+
+	// eventPollInterval is how often the listener checks for events that it hasn't been
+	// notified about. Events are held back until every older transaction has finished
+	// (see data.MovieEventModel.GetAfter()), and there's no notification when a
+	// transaction which didn't record any events finishes.
+	eventPollInterval = time.Second
+
+	// eventBatchSize is how many events the listener reads at a time.
+	eventBatchSize = 100
+)
+
+// movieEventHub fans the movie events read by the listener out to the clients which are
+// streaming them, in the order they were read. Each subscriber gets its own buffered
+// channel, which the hub closes to disconnect the subscriber. It's safe for concurrent
+// use.
+type movieEventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.MovieEvent]struct{}
+	closed      bool
+}
+
+func newMovieEventHub() *movieEventHub {
+	return &movieEventHub{subscribers: make(map[chan *data.MovieEvent]struct{})}
+}
+
+// subscribe() returns a new channel of events, or false if the hub has been shut down.
+func (h *movieEventHub) subscribe() (chan *data.MovieEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, false
+	}
+
+	ch := make(chan *data.MovieEvent, eventBufferSize)
+	h.subscribers[ch] = struct{}{}
+
+	return ch, true
+}
+
+// unsubscribe() removes a subscriber, closing its channel unless the hub already has.
+func (h *movieEventHub) unsubscribe(ch chan *data.MovieEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// hasSubscribers() reports whether anyone is listening, so that the listener can avoid
+// fetching events which nobody wants.
+func (h *movieEventHub) hasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers) > 0
+}
+
+// publish() sends an event to every subscriber without blocking. Subscribers whose
+// buffers are full are disconnected; they can resume from the last event they got.
+func (h *movieEventHub) publish(event *data.MovieEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// disconnectAll() disconnects every subscriber, so that the clients resume from the
+// last event they got.
+func (h *movieEventHub) disconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// shutdown() disconnects every subscriber and refuses new ones. It's called when the
+// server starts shutting down, as srv.Shutdown() waits for every request to finish and
+// the streams would otherwise never end.
+func (h *movieEventHub) shutdown() {
+	h.disconnectAll()
+
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+}
+
+// The listenForMovieEvents() method reads each new movie event in order and publishes
+// it to the hub, until the context is cancelled. Postgres notifies us when events are
+// recorded, so that they're read straight away, and we check every eventPollInterval
+// for any which were held back when we were notified.
+func (app *application) listenForMovieEvents(ctx context.Context) {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	// Closing the listener also unblocks Listen(), which waits for as long as it takes
+	// to connect.
+	context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+
+	err := listener.Listen(data.MovieEventsChannel)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.Error(err.Error())
+		}
+		return
+	}
+
+	var (
+		position data.EventPosition
+		started  bool
+	)
+
+	// publishNew() publishes the events after the last one published. While nobody's
+	// listening it just skips ahead to the latest event instead, as clients replay
+	// whatever they've missed for themselves when they connect. Checking for
+	// subscribers again after looking up the latest event means that a client which
+	// subscribes in between doesn't miss the events that would be skipped.
+	publishNew := func() {
+		if !started || !app.events.hasSubscribers() {
+			latest, err := app.models.Events.Latest()
+			if err != nil {
+				app.logger.Error(err.Error())
+				return
+			}
+
+			switch {
+			case !started:
+				// Clients which connected before we started might have been waiting
+				// from an earlier event than this one, so they're sent away to resume.
+				position, started = latest, true
+				app.events.disconnectAll()
+				return
+			case !app.events.hasSubscribers():
+				position = latest
+				return
+			}
+		}
+
+		for {
+			events, err := app.models.Events.GetAfter(position, eventBatchSize)
+			if err != nil {
+				app.logger.Error(err.Error())
+				return
+			}
+
+			for _, event := range events {
+				app.events.publish(event)
+				position = event.Position()
+			}
+
+			if len(events) < eventBatchSize {
+				return
+			}
+		}
+	}
+
+	publishNew()
+
+	// Ping the connection every now and then, so that we notice if it has dropped
+	// while there aren't any notifications.
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			go listener.Ping()
+		case <-poll.C:
+			publishNew()
+		case <-listener.Notify:
+			// The notifications only tell us that there's something to read, so it
+			// doesn't matter which events they're for, or whether any were lost while
+			// the connection was down (which is signalled by a nil notification).
+			// Any others which have already arrived are covered by the same read.
+		drain:
+			for {
+				select {
+				case <-listener.Notify:
+				default:
+					break drain
+				}
+			}
+
+			publishNew()
+		}
+	}
+}
+
+// readLastEventID() returns the position of the last event that a client got, which
+// is sent as the ID of each event. Browsers send it in the Last-Event-ID header when
+// they reconnect, and clients can pass it in the last_event_id query string parameter
+// to resume a stream they've started again. It returns false if the client isn't
+// resuming.
+func (app *application) readLastEventID(r *http.Request, v *validator.Validator) (data.EventPosition, bool) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+
+	if s == "" {
+		return data.EventPosition{}, false
+	}
+
+	position, err := data.ParseEventPosition(s)
+	if err != nil {
+		v.AddError("last_event_id", "must be the ID of an event from the stream")
+		return data.EventPosition{}, false
+	}
+
+	return position, true
+}
+
+// writeEvent() writes a movie event to w in the Server-Sent Events format, with its
+// position as the ID that the client resumes from.
+func writeEvent(w io.Writer, event *data.MovieEvent) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Position(), event.Type, js)
+	return err
+}
+
+// Stream changes to movies to the client as Server-Sent Events, as they're committed.
+// The ID of each message can be used to resume the stream after a disconnection.
+// Events which were missed are replayed first, unless there are too many, in which case
+// a "resync" event tells the client to reload whatever it's showing instead.
+func (app *application) streamMovieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	position, resuming := app.readLastEventID(r, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Subscribe before looking up the missed events, so that nothing committed in
+	// between falls through the gap. Any events we get twice are skipped below.
+	events, ok := app.events.subscribe()
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("the movie events stream has shut down"))
+		return
+	}
+
+	defer app.events.unsubscribe(events)
+
+	var (
+		missed []*data.MovieEvent
+		resync bool
+		err    error
+	)
+
+	// Clients which aren't resuming only get the events recorded from now on.
+	if resuming {
+		missed, err = app.models.Events.GetAfter(position, maxEventReplay+1)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(missed) > maxEventReplay {
+			missed, resync = nil, true
+		}
+	} else {
+		position, err = app.models.Events.Latest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+
+	// send() writes to the stream and flushes it straight away. The stream stays open
+	// for much longer than the server's write timeout, so each write gets its own
+	// deadline instead.
+	send := func(write func() error) error {
+		err := rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if err != nil {
+			return err
+		}
+
+		err = write()
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = send(func() error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+		if err != nil {
+			return err
+		}
+
+		if resync {
+			_, err = io.WriteString(w, "event: resync\ndata: {}\n\n")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, event := range missed {
+			err = writeEvent(w, event)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// The hub publishes events in the same order that GetAfter() reads them in, so any
+	// event we get which isn't after the last one we sent must have been replayed
+	// already.
+	if len(missed) > 0 {
+		position = missed[len(missed)-1].Position()
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			// The hub has disconnected us, either because we fell behind or because
+			// the server is shutting down. The client will reconnect and resume.
+			if !ok {
+				return
+			}
+
+			if !event.Position().After(position) {
+				continue
+			}
+
+			position = event.Position()
+
+			err = send(func() error {
+				return writeEvent(w, event)
+			})
+		case <-heartbeat.C:
+			err = send(func() error {
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err
+			})
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
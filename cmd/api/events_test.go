@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+func TestMovieEventHub(t *testing.T) {
+	h := newMovieEventHub()
+
+	fast, _ := h.subscribe()
+	slow, _ := h.subscribe()
+
+	// Fill the slow subscriber's buffer, draining the fast one as we go.
+	for id := int64(1); id <= eventBufferSize; id++ {
+		h.publish(&data.MovieEvent{ID: id})
+		<-fast
+	}
+
+	// The next event doesn't fit, so the slow subscriber is disconnected.
+	h.publish(&data.MovieEvent{ID: eventBufferSize + 1})
+
+	if event := <-fast; event.ID != eventBufferSize+1 {
+		t.Errorf("got event %d, want %d", event.ID, eventBufferSize+1)
+	}
+
+	for range eventBufferSize {
+		<-slow
+	}
+
+	if _, ok := <-slow; ok {
+		t.Error("the slow subscriber wasn't disconnected")
+	}
+
+	// Unsubscribing after being disconnected is safe.
+	h.unsubscribe(slow)
+
+	h.shutdown()
+
+	if _, ok := <-fast; ok {
+		t.Error("the subscriber wasn't disconnected on shutdown")
+	}
+
+	if _, ok := h.subscribe(); ok {
+		t.Error("subscribed after shutdown")
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	event := &data.MovieEvent{
+		ID:        7,
+		TxID:      900,
+		Type:      data.EventMovieUpdated,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		MovieID:   3,
+		Version:   2,
+		Snapshot:  json.RawMessage(`{"title":"Moana"}`),
+		Changes:   json.RawMessage(`{"title":{"from":"Moana 2","to":"Moana"}}`),
+	}
+
+	var sb strings.Builder
+
+	err := writeEvent(&sb, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id: 900-7\nevent: movie.updated\n" +
+		`data: {"id":7,"type":"movie.updated","created_at":"2024-01-02T03:04:05Z","movie_id":3,"version":2,"snapshot":{"title":"Moana"},"changes":{"title":{"from":"Moana 2","to":"Moana"}}}` +
+		"\n\n"
+
+	if sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+}
+
+func TestReadLastEventID(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		query        string
+		want         data.EventPosition
+		wantResuming bool
+		wantValid    bool
+	}{
+		{"None", "", "", data.EventPosition{}, false, true},
+		{"Header", "900-42", "", data.EventPosition{TxID: 900, ID: 42}, true, true},
+		{"Query", "", "900-42", data.EventPosition{TxID: 900, ID: 42}, true, true},
+		{"Header wins", "900-42", "800-7", data.EventPosition{TxID: 900, ID: 42}, true, true},
+		{"Start", "0-0", "", data.EventPosition{}, true, true},
+		{"Event ID only", "42", "", data.EventPosition{}, false, false},
+		{"Negative", "900--1", "", data.EventPosition{}, false, false},
+		{"Not a number", "abc-def", "", data.EventPosition{}, false, false},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/events?last_event_id="+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("Last-Event-ID", tt.header)
+			}
+
+			v := validator.New()
+
+			position, resuming := app.readLastEventID(r, v)
+
+			if position != tt.want || resuming != tt.wantResuming || v.Valid() != tt.wantValid {
+				t.Errorf("got %s, %t, valid %t; want %s, %t, valid %t", position, resuming, v.Valid(), tt.want, tt.wantResuming, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestEventPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		p, q data.EventPosition
+		want bool
+	}{
+		{"Later transaction", data.EventPosition{TxID: 901, ID: 1}, data.EventPosition{TxID: 900, ID: 2}, true},
+		{"Earlier transaction", data.EventPosition{TxID: 900, ID: 2}, data.EventPosition{TxID: 901, ID: 1}, false},
+		{"Later ID", data.EventPosition{TxID: 900, ID: 2}, data.EventPosition{TxID: 900, ID: 1}, true},
+		{"Same", data.EventPosition{TxID: 900, ID: 1}, data.EventPosition{TxID: 900, ID: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.After(tt.q); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamMovieEvents(t *testing.T) {
+	// The client isn't resuming, so the handler just looks up the latest event, which
+	// fakeUserDB says there isn't.
+	db := sql.OpenDB(&fakeUserDB{})
+	defer db.Close()
+
+	app := &application{events: newMovieEventHub(), models: data.NewModels(db)}
+
+	srv := httptest.NewServer(http.HandlerFunc(app.streamMovieEventsHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got Content-Type %q, want text/event-stream", ct)
+	}
+
+	body := bufio.NewReader(resp.Body)
+
+	readMessage := func() string {
+		var lines []string
+
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	if got := readMessage(); got != "retry: 5000\n" {
+		t.Errorf("got %q, want the retry interval", got)
+	}
+
+	// The handler subscribes before writing the headers, so the event can't be
+	// published before it's listening.
+	app.events.publish(&data.MovieEvent{ID: 1, Type: data.EventMovieCreated})
+
+	if got := readMessage(); !strings.HasPrefix(got, "id: 0-1\nevent: movie.created\n") {
+		t.Errorf("got %q, want event 1", got)
+	}
+
+	// An event which isn't after the last one sent is skipped.
+	app.events.publish(&data.MovieEvent{ID: 1, Type: data.EventMovieCreated})
+	app.events.publish(&data.MovieEvent{ID: 2, Type: data.EventMovieUpdated})
+
+	if got := readMessage(); !strings.HasPrefix(got, "id: 0-2\nevent: movie.updated\n") {
+		t.Errorf("got %q, want event 2", got)
+	}
+
+	// Shutting the hub down ends the stream.
+	app.events.shutdown()
+
+	if _, err := io.ReadAll(body); err != nil {
+		t.Errorf("got error %v reading the rest of the stream", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+)
+
+// The sparseFields type wraps a value so that only the selected fields of it (or of
+// each of its elements, if it encodes to a JSON array) are included when it is encoded
+// as JSON. The fields keep the order they have in the full representation.
+type sparseFields struct {
+	value  any
+	fields []string
+}
+
+// The sparse() helper applies a sparse fieldset to a value before it is passed to
+// writeJSON(), e.g. envelope{"movies": sparse(movies, fields)}. If no fields were
+// requested, the value is returned unchanged.
+func sparse(value any, fields []string) any {
+	if len(fields) == 0 {
+		return value
+	}
+
+	return sparseFields{value: value, fields: fields}
+}
+
+func (s sparseFields) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(s.value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(js, []byte("[")) {
+		return s.filterObject(js)
+	}
+
+	var elements []json.RawMessage
+
+	err = json.Unmarshal(js, &elements)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, element := range elements {
+		elements[i], err = s.filterObject(element)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(elements)
+}
+
+// filterObject() removes the members of a JSON object which aren't in the fieldset.
+// Values which aren't objects are returned unchanged.
+func (s sparseFields) filterObject(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+
+	tok, err := dec.Token()
+	if err != nil || tok != json.Delim('{') {
+		return js, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var value json.RawMessage
+
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		key, _ := tok.(string)
+		if !slices.Contains(s.fields, key) {
+			continue
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
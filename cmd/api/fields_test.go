@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+func TestSparse(t *testing.T) {
+	movie := &data.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 3}
+
+	tests := []struct {
+		name   string
+		value  any
+		fields []string
+		want   string
+	}{
+		{
+			name:  "no fieldset",
+			value: movie,
+			want:  `{"id":1,"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"],"version":3}`,
+		},
+		{
+			name:   "object keeps field order",
+			value:  movie,
+			fields: []string{"version", "title"},
+			want:   `{"title":"Moana","version":3}`,
+		},
+		{
+			name:   "slice",
+			value:  []*data.Movie{movie, movie},
+			fields: []string{"id"},
+			want:   `[{"id":1},{"id":1}]`,
+		},
+		{
+			name:   "empty slice",
+			value:  []*data.Movie{},
+			fields: []string{"id"},
+			want:   `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := json.Marshal(sparse(tt.value, tt.fields))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(js) != tt.want {
+				t.Errorf("got %s, want %s", js, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/travboz/greenlightv3/internal/data/validator"
@@ -20,14 +21,20 @@ type envelope map[string]any
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// The readInt64Param() helper works like readIDParam(), but for any named URL parameter
+// which should hold a positive integer.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	value, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return id, nil
+	return value, nil
 }
 
 // Define a writeJSON() helper for sending responses. This takes the destination
@@ -212,6 +219,48 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readBool() helper reads a boolean value from the query string. If no matching key
+// could be found it returns nil, so that callers can tell "not provided" apart from
+// false. If the value couldn't be parsed, then we record an error message in the
+// provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// The readTime() helper reads a timestamp from the query string, either in RFC 3339
+// format or as a plain date (which is taken to be midnight UTC). If no matching key
+// could be found it returns the zero time. If the value couldn't be parsed, it records
+// an error in the provided Validator instance.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be a date (2006-01-02) or an RFC 3339 timestamp")
+	return time.Time{}
+}
+
 // background() runs any given function in the background and accepts some arbitrary function as a param.
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/travboz/greenlightv3/internal/storage"
 	"github.com/travboz/greenlightv3/internal/vcs"
 	"github.com/travboz/greenlightv3/pkg/env"
 )
@@ -48,7 +49,14 @@ func main() {
 
 	publishMetrics(db)
 
-	app := NewApplication(cfg, logger, db)
+	// Open the blob store for uploaded files.
+	store, err := storage.NewFileStore(cfg.storage.dir)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	app := NewApplication(cfg, logger, db, store)
 
 	// Start server with app.serve()
 	err = app.serve()
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Merge a duplicate movie into the movie in the URL. The duplicate's credits, ratings,
+// reviews, alternate titles and watchlist items are moved across, as are its IMDb and
+// TMDb IDs if the movie has none, and the duplicate is moved to the trash.
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		DuplicateID int64 `json:"duplicate_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.DuplicateID > 0, "duplicate_id", "must be provided")
+	v.Check(input.DuplicateID != id, "duplicate_id", "must not be the movie being merged into")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that both movies exist, so that we can tell the client which one doesn't.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.models.Movies.Get(input.DuplicateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("duplicate_id", "must refer to an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Merge() returns ErrRecordNotFound if either movie was trashed after the checks
+	// above.
+	result, err := app.models.Movies.Merge(id, input.DuplicateID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the movie again, as the merge has changed its version and rating
+	// aggregates.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie, "merged": result}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
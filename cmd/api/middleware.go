@@ -450,6 +450,9 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// Let browser clients read the ETag header, so that they can make
+					// conditional requests.
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
@@ -457,7 +460,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// Set the necessary preflight response headers, as discussed
 						// previously.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
 						w.WriteHeader(http.StatusOK)
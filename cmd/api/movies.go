@@ -3,7 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
 
 	"github.com/travboz/greenlightv3/internal/data"
 	"github.com/travboz/greenlightv3/internal/data/validator"
@@ -13,10 +16,13 @@ import (
 // return a plain-text placeholder response.
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title    string        `json:"title"`
+		Year     int32         `json:"year"`
+		Runtime  data.Runtime  `json:"runtime"`
+		Genres   []string      `json:"genres"`
+		ImdbID   string        `json:"imdb_id"`
+		TmdbID   int64         `json:"tmdb_id"`
+		Releases data.Releases `json:"releases"`
 	}
 
 	err := app.readJSON(w, r, &payload)
@@ -27,29 +33,71 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy the values from the payload struct to a new Movie struct.
 	movie := &data.Movie{
-		Title:   payload.Title,
-		Year:    payload.Year,
-		Runtime: payload.Runtime,
-		Genres:  payload.Genres,
+		Title:    payload.Title,
+		Year:     payload.Year,
+		Runtime:  payload.Runtime,
+		Genres:   payload.Genres,
+		ImdbID:   payload.ImdbID,
+		TmdbID:   payload.TmdbID,
+		Releases: payload.Releases,
 	}
 
 	// Initialize a new Validator instance.
 	v := validator.New()
 
+	// Read the optional force parameter, which skips the check for duplicates.
+	force := false
+	if b := app.readBool(r.URL.Query(), "force", v); b != nil {
+		force = *b
+	}
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Use the Valid() method to see if any of the checks failed. If they did, then use
 	// the failedValidationResponse() helper to send a response to the client, passing
 	// in the v.Errors map.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Unless the client has forced it, refuse to create a movie which looks like one we
+	// already have.
+	if !force {
+		duplicates, err := app.models.Movies.FindDuplicates(movie.Title, movie.Year)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			app.duplicateMovieResponse(w, r, duplicates)
+			return
+		}
+	}
+
 	// Call the Insert() method on our movies model, passing in a pointer to the
-	// validated movie struct. This will create a record in the database and update the
-	// movie struct with the system-generated information.
-	err = app.models.Movies.Insert(movie)
+	// validated movie struct and the ID of the current user (for the movie's history).
+	// This will create a record in the database and update the movie struct with the
+	// system-generated information.
+	// If one of the movie's external IDs already belongs to another movie, send the
+	// client a validation error.
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateImdbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTmdbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -87,10 +135,21 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// 	Version:   1,
 	// }
 
-	// Call the Get() method to fetch the data for a specific movie. We also need to
-	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
+	// Read the optional sparse fieldset, which limits the fields in the response (and
+	// the columns that we fetch from the database).
+	v := validator.New()
+
+	fields := app.readCSV(r.URL.Query(), "fields", []string{})
+
+	if data.ValidateFields(v, fields, data.MovieFieldsSafelist); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Call the GetFields() method to fetch the data for a specific movie. We also need
+	// to use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -102,7 +161,27 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Use the movie's title in the client's preferred language, if it has one. There's
+	// no need when the title isn't one of the requested fields.
+	if len(fields) == 0 || slices.Contains(fields, "title") {
+		err = app.localizeMovies(w, r, movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// If the client already has the current version of the movie, send a 304 Not
+	// Modified response instead of the movie itself.
+	etag := movieETag(movie)
+	if app.checkIfNoneMatch(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": sparse(movie, fields)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -129,71 +208,128 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	// Use pointers for partial updates and checking for nil value.
-	var payload struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &payload)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// If the client sent an If-Match header, make sure that it still refers to the
+	// current version of the movie. Otherwise, the client's changes were based on
+	// stale data, so we send a 412 Precondition Failed response.
+	if !app.checkMovieIfMatch(w, r, movie) {
 		return
 	}
 
-	// Copy the values from the request body to the appropriate fields of the movie
-	// record.
-	// If the input.Title value is nil then we know that no corresponding "title" key/
-	// value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, because input.Title is a now a pointer to a string, we need
-	// to dereference the pointer using the * operator to get the underlying value
-	// before assigning it to our movie record.
-	if payload.Title != nil {
-		movie.Title = *payload.Title
-	}
+	v := validator.New()
 
-	// We also do the same for the other fields in the input struct.
-	if payload.Year != nil {
-		movie.Year = *payload.Year
-	}
+	// If the client sent a JSON Merge Patch or JSON Patch document, apply it to the
+	// movie. Any operations which can't be applied are reported as validation errors.
+	if mediaType := patchMediaType(r); mediaType != "" {
+		err = app.readMoviePatch(w, r, mediaType, movie, v)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	if payload.Runtime != nil {
-		movie.Runtime = *payload.Runtime
-	}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	} else {
+		// Declare an input struct to hold the expected data from the client.
+		// Use pointers for partial updates and checking for nil value.
+		var payload struct {
+			Title    *string       `json:"title"`
+			Year     *int32        `json:"year"`
+			Runtime  *data.Runtime `json:"runtime"`
+			Genres   []string      `json:"genres"`
+			ImdbID   *string       `json:"imdb_id"`
+			TmdbID   *int64        `json:"tmdb_id"`
+			Releases data.Releases `json:"releases"`
+		}
+
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	if payload.Genres != nil {
-		movie.Genres = payload.Genres // Note that we don't need to dereference a slice.
+		// Copy the values from the request body to the appropriate fields of the movie
+		// record.
+		// If the input.Title value is nil then we know that no corresponding "title" key/
+		// value pair was provided in the JSON request body. So we move on and leave the
+		// movie record unchanged. Otherwise, we update the movie record with the new title
+		// value. Importantly, because input.Title is a now a pointer to a string, we need
+		// to dereference the pointer using the * operator to get the underlying value
+		// before assigning it to our movie record.
+		if payload.Title != nil {
+			movie.Title = *payload.Title
+		}
+
+		// We also do the same for the other fields in the input struct.
+		if payload.Year != nil {
+			movie.Year = *payload.Year
+		}
+
+		if payload.Runtime != nil {
+			movie.Runtime = *payload.Runtime
+		}
+
+		if payload.Genres != nil {
+			movie.Genres = payload.Genres // Note that we don't need to dereference a slice.
+		}
+
+		// An empty string or zero clears an external ID, and an empty array clears the
+		// releases.
+		if payload.ImdbID != nil {
+			movie.ImdbID = *payload.ImdbID
+		}
+
+		if payload.TmdbID != nil {
+			movie.TmdbID = *payload.TmdbID
+		}
+
+		if payload.Releases != nil {
+			movie.Releases = payload.Releases
+		}
 	}
 
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity
 	// response if any checks fail.
-	v := validator.New()
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Intercept any ErrEditConflict error and call the new editConflictResponse()
-	// helper.
-	err = app.models.Movies.Update(movie)
+	// helper. If the client made a conditional request, the movie has changed since
+	// the If-Match header was checked, so we send a 412 response instead.
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateImdbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTmdbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -207,11 +343,39 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// If the client sent an If-Match header, fetch the movie and check that the header
+	// refers to its current version. We then only delete the movie if it is still at
+	// that version, so that a change made in the meantime isn't silently discarded.
+	var version int32
+
+	conditional := r.Header.Get("If-Match") != ""
+	if conditional {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkMovieIfMatch(w, r, movie) {
+			return
+		}
+
+		version = movie.Version
+	}
+
+	// Move the movie to the trash, sending a 404 Not Found response to the client if
+	// there isn't a matching record (or a 412 Precondition Failed response if the
+	// movie was changed after we checked the If-Match header).
+	err = app.models.Movies.Delete(id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordNotFound) && conditional:
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -221,7 +385,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Return a 204 status code along with a success message.
-	err = app.writeJSON(w, http.StatusNoContent, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusNoContent, envelope{"message": "movie successfully moved to the trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -231,8 +395,8 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// To keep things consistent with our other handlers, we'll define an input struct
 	// to hold the expected values from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
+		Fields []string
 		data.Filters
 	}
 
@@ -242,11 +406,10 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and genres query string values, falling back
-	// to defaults of an empty string and an empty slice respectively if they are not
-	// provided by the client.
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	// Read the search conditions, and the optional sparse fieldset, which limits the
+	// fields included for each movie in the response.
+	input.MovieSearch = app.readMovieSearch(qs, v)
+	input.Fields = app.readCSV(qs, "fields", []string{})
 
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
@@ -259,10 +422,15 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
 	// Add the supported sort values for this endpoint to the sort safelist.
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	// "relevance" always sorts the best title matches first, so it has no descending
+	// form. "rating" sorts on the average user rating.
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "-id", "-title", "-year", "-runtime", "-rating"}
+
+	// Execute the validation checks on the search, fieldset and Filters struct and send
+	// a response containing the errors if necessary.
+	data.ValidateMovieSearch(v, input.MovieSearch)
+	data.ValidateFields(v, input.Fields, data.MovieFieldsSafelist)
 
-	// Execute the validation checks on the Filters struct and send a response
-	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -270,15 +438,286 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieSearch, input.Fields, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if len(input.Fields) == 0 || slices.Contains(input.Fields, "title") {
+		err = app.localizeMovies(w, r, movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The ETag for the list changes whenever any of the movies on the page do, so
+	// clients can poll for changes cheaply using If-None-Match.
+	etag := moviesETag(movies, metadata)
+	if app.checkIfNoneMatch(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
 	// Include the metadata in the response envelope.
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": sparse(movies, input.Fields), "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readMovieSearch() helper reads the search conditions for listing movies from the
+// query string. Any errors are recorded in the provided Validator instance, but the
+// search itself isn't validated.
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieSearch {
+	var search data.MovieSearch
+
+	// Use our helpers to extract the title and genres query string values, falling back
+	// to defaults of an empty string and an empty slice respectively if they are not
+	// provided by the client. The original genres parameter is kept as an alias for
+	// genres_all.
+	search.Title = app.readString(qs, "title", "")
+	search.GenresAll = app.readCSV(qs, "genres_all", app.readCSV(qs, "genres", []string{}))
+	search.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	search.GenresExclude = app.readCSV(qs, "genres_exclude", []string{})
+
+	// Fuzzy matching of titles, which allows for typos, is opt-in.
+	if fuzzy := app.readBool(qs, "fuzzy", v); fuzzy != nil {
+		search.Fuzzy = *fuzzy
+	}
+
+	// Read the range filters. A value of zero means that the filter isn't applied.
+	search.YearMin = app.readInt(qs, "year_min", 0, v)
+	search.YearMax = app.readInt(qs, "year_max", 0, v)
+	search.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	search.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	search.CreatedAfter = app.readTime(qs, "created_after", v)
+	search.CreatedBefore = app.readTime(qs, "created_before", v)
+
+	// Read the external IDs, for looking up a movie from another database.
+	search.ImdbID = app.readString(qs, "imdb_id", "")
+	search.TmdbID = app.readInt(qs, "tmdb_id", 0, v)
+
+	return search
+}
+
+// Show the facet counts (by genre, decade and runtime) for the movies matching the
+// same search conditions as listMoviesHandler.
+func (app *application) listMovieFacetsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	search := app.readMovieSearch(r.URL.Query(), v)
+
+	if data.ValidateMovieSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	facets, err := app.models.Movies.GetFacets(search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"facets": facets}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the movies which are currently in the trash, most recently deleted first.
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrashed(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Take a movie back out of the trash.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the revision history of a movie, newest first by default. The history is still
+// available for movies in the trash.
+func (app *application) showMovieHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAllForMovie(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Every movie has at least one revision, so an empty history (on the first page)
+	// means that the movie doesn't exist.
+	if len(revisions) == 0 && input.Filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Roll a movie back to how it was at an earlier version. The revert is saved as a new
+// version, so it shows up in the history and can itself be undone.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readInt64Param(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(id, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Validation rules may have changed since the revision was made, so check the
+	// reverted movie before saving it.
+	revision.Snapshot.ApplyTo(movie)
+
+	v := validator.New()
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Revert(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateImdbID):
+			v.AddError("imdb_id", "a movie with this IMDb ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateTmdbID):
+			v.AddError("tmdb_id", "a movie with this TMDB ID already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateMovie() helper checks a movie with data.ValidateMovie(), recording any
+// problems in v. The movie's genres must be chosen from the taxonomy, so this fetches
+// the slugs of the genres in it first, and only returns an error if that fails.
+func (app *application) validateMovie(v *validator.Validator, movie *data.Movie) error {
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		return err
+	}
+
+	data.ValidateMovie(v, movie, knownGenres)
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+	"github.com/travboz/greenlightv3/internal/jsonpatch"
+)
+
+// The patchMediaType() helper returns the media type of the request body if it is one
+// of the patch formats we support, or an empty string otherwise.
+func patchMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case jsonpatch.MergePatchMediaType, jsonpatch.PatchMediaType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// The readMoviePatch() helper reads a JSON Merge Patch or JSON Patch document from the
+// request body and applies it to the editable fields of the movie. The patch is applied
+// to the same representation of the movie that clients see, e.g.
+//
+//	{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"],
+//	 "imdb_id": "tt3521164", "tmdb_id": 277834, "releases": [{"country": "US", ...}]}
+//
+// so a JSON Patch can append a genre with {"op": "add", "path": "/genres/-", ...}, and
+// removing a field (or setting it to null) clears it. A badly-formed request body is
+// returned as an error, while operations that can't be applied, and fields with invalid
+// values, are added to the validator.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie, v *validator.Validator) error {
+	var patch json.RawMessage
+
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(data.SnapshotMovie(movie))
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case jsonpatch.MergePatchMediaType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return err
+		}
+
+	case jsonpatch.PatchMediaType:
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return err
+		}
+
+		patched, err = ops.Apply(doc)
+		if err != nil {
+			var opErr *jsonpatch.OperationError
+
+			switch {
+			case errors.As(err, &opErr):
+				v.AddError("patch", opErr.Error())
+				return nil
+			default:
+				return err
+			}
+		}
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(patched, &fields)
+	if err != nil || fields == nil {
+		v.AddError("patch", "the patched movie must be a JSON object")
+		return nil
+	}
+
+	// Decode each field separately, so that we can report exactly which ones have
+	// invalid values. Fields which have been removed, or set to null, are left with
+	// their zero value.
+	var snapshot data.MovieSnapshot
+
+	targets := map[string]struct {
+		dst     any
+		message string
+	}{
+		"title":    {&snapshot.Title, "must be a string"},
+		"year":     {&snapshot.Year, "must be an integer"},
+		"runtime":  {&snapshot.Runtime, `must be a string in the format "<runtime> mins"`},
+		"genres":   {&snapshot.Genres, "must be an array of strings"},
+		"imdb_id":  {&snapshot.ImdbID, "must be a string"},
+		"tmdb_id":  {&snapshot.TmdbID, "must be an integer"},
+		"releases": {&snapshot.Releases, "must be an array of release objects"},
+	}
+
+	for key, value := range fields {
+		target, ok := targets[key]
+		if !ok {
+			v.AddError(key, "cannot be changed")
+			continue
+		}
+
+		if string(value) == "null" {
+			continue
+		}
+
+		if json.Unmarshal(value, target.dst) != nil {
+			v.AddError(key, target.message)
+		}
+	}
+
+	if v.Valid() {
+		snapshot.ApplyTo(movie)
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+	"github.com/travboz/greenlightv3/internal/storage"
+
+	// Register the GIF and PNG decoders with the image package, for decoding uploaded
+	// posters (the JPEG decoder is registered by the import above).
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Define limits for poster uploads. Images are much larger than the JSON bodies that
+// readJSON() accepts, so uploads are read separately with their own size limit, and
+// the image dimensions are limited too so that decoding one can't use up too much
+// memory.
+const (
+	maxPosterBytes     = 10 << 20
+	maxPosterDimension = 4000
+	posterReadTimeout  = time.Minute
+)
+
+// posterFormats maps the content types we accept for posters (as detected by sniffing
+// the uploaded bytes) to the file extension used when storing them.
+var posterFormats = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// posterSizes lists the thumbnails generated for each poster, by name and width in
+// pixels. The heights are scaled to keep the poster's aspect ratio.
+var posterSizes = []struct {
+	name  string
+	width int
+}{
+	{"small", 185},
+	{"medium", 342},
+}
+
+// Upload a new poster for a movie, replacing its current one. The image is sent as the
+// "poster" field of a multipart/form-data body. Thumbnails are generated in the
+// background, and appear in the movie's poster JSON once they are ready.
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Uploading a large image over a slow connection can take longer than our server's
+	// read timeout allows, so extend the deadline for this request.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(posterReadTimeout))
+
+	// Leave some room on top of the image size limit for the rest of the multipart
+	// body.
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+64<<10)
+
+	img, err := readPosterPart(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, http.ErrNotMultipart):
+			app.unsupportedMediaTypeResponse(w, r)
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("poster must not be larger than %d bytes", maxPosterBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Check the type of the image by sniffing its bytes, rather than trusting the
+	// Content-Type header or file name sent by the client, and check its dimensions.
+	v := validator.New()
+
+	contentType := http.DetectContentType(img)
+	ext, ok := posterFormats[contentType]
+	v.Check(ok, "poster", "must be a JPEG, PNG or GIF image")
+
+	if ok {
+		config, _, err := image.DecodeConfig(bytes.NewReader(img))
+		v.Check(err == nil, "poster", "must be a valid image")
+		v.Check(err != nil || (config.Width <= maxPosterDimension && config.Height <= maxPosterDimension), "poster", fmt.Sprintf("must not be more than %d pixels wide or high", maxPosterDimension))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Name the blob after a hash of its contents, so that each upload gets its own URL
+	// and the files can be cached forever.
+	sum := sha256.Sum256(img)
+	key := fmt.Sprintf("posters/%d/%x%s", id, sum[:8], ext)
+
+	// Check that the movie exists before storing anything.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.storage.Put(r.Context(), key, bytes.NewReader(img))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie, oldKey, err := app.models.Movies.SetPoster(id, key, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		app.generatePosterThumbnails(movie.ID, key, img)
+
+		if oldKey != "" && oldKey != key {
+			app.deletePoster(oldKey)
+		}
+	})
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPosterPart() reads the contents of the "poster" field from a multipart request
+// body. Any other fields are ignored.
+func readPosterPart(r *http.Request) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New(`body must contain a "poster" field`)
+			}
+			return nil, err
+		}
+
+		if part.FormName() != "poster" {
+			continue
+		}
+
+		img, err := io.ReadAll(io.LimitReader(part, maxPosterBytes+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(img) > maxPosterBytes {
+			return nil, &http.MaxBytesError{Limit: maxPosterBytes}
+		}
+
+		if len(img) == 0 {
+			return nil, errors.New("poster must not be empty")
+		}
+
+		return img, nil
+	}
+}
+
+// generatePosterThumbnails() creates a JPEG thumbnail of the poster in each of the
+// posterSizes, and then records that they are ready.
+func (app *application) generatePosterThumbnails(movieID int64, key string, img []byte) {
+	src, _, err := image.Decode(bytes.NewReader(img))
+	if err != nil {
+		app.logger.Error(err.Error(), "poster", key)
+		return
+	}
+
+	var sizes []string
+
+	for _, size := range posterSizes {
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, scaleToWidth(src, size.width), &jpeg.Options{Quality: 85})
+		if err != nil {
+			app.logger.Error(err.Error(), "poster", key)
+			return
+		}
+
+		err = app.storage.Put(context.Background(), data.PosterThumbnailKey(key, size.name), &buf)
+		if err != nil {
+			app.logger.Error(err.Error(), "poster", key)
+			return
+		}
+
+		sizes = append(sizes, size.name)
+	}
+
+	err = app.models.Movies.SetPosterThumbnails(movieID, key, sizes)
+	if err != nil {
+		app.logger.Error(err.Error(), "poster", key)
+	}
+}
+
+// deletePoster() removes a poster which has been replaced (or whose movie has been
+// purged), along with its thumbnails.
+func (app *application) deletePoster(key string) {
+	keys := []string{key}
+	for _, size := range posterSizes {
+		keys = append(keys, data.PosterThumbnailKey(key, size.name))
+	}
+
+	for _, key := range keys {
+		err := app.storage.Delete(context.Background(), key)
+		if err != nil {
+			app.logger.Error(err.Error(), "poster", key)
+		}
+	}
+}
+
+// scaleToWidth() shrinks an image to the given width, keeping its aspect ratio. Each
+// pixel of the result is the average of the block of source pixels that it covers,
+// and transparent areas are flattened onto a white background (as the thumbnails are
+// JPEGs). Images which are already narrow enough keep their size.
+func scaleToWidth(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	if width > sw {
+		width = sw
+	}
+
+	height := max(1, (sh*width+sw/2)/sw)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := bounds.Min.Y + y*sh/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*sh/height)
+
+		for x := range width {
+			x0 := bounds.Min.X + x*sw/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*sw/width)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			// The colour values are alpha-premultiplied, so adding the missing alpha
+			// to each channel composites the pixel over white.
+			white := n*0xffff - a
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
+
+// Serve a blob from the blob store, such as a movie poster. Blob keys include a hash of
+// their contents, so the responses can be cached indefinitely.
+func (app *application) serveMediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+
+	rc, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		app.logger.Error(err.Error(), "key", key)
+	}
+}
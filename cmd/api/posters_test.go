@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+func TestScaleToWidth(t *testing.T) {
+	// A 4x2 image with a red left half and a transparent right half.
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 2 {
+			src.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+
+	dst := scaleToWidth(src, 2)
+
+	if got := dst.Bounds().Size(); got != image.Pt(2, 1) {
+		t.Fatalf("got size %v, want (2,1)", got)
+	}
+
+	tests := []struct {
+		x    int
+		want color.RGBA
+	}{
+		{x: 0, want: color.RGBA{R: 0xff, A: 0xff}},
+		// Transparent pixels are flattened onto white.
+		{x: 1, want: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+	}
+
+	for _, tt := range tests {
+		if got := color.RGBAModel.Convert(dst.At(tt.x, 0)); got != tt.want {
+			t.Errorf("pixel %d: got %v, want %v", tt.x, got, tt.want)
+		}
+	}
+
+	// Images which are already narrow enough aren't enlarged.
+	if got := scaleToWidth(src, 100).Bounds().Size(); got != image.Pt(4, 2) {
+		t.Errorf("got size %v when scaling up, want (4,2)", got)
+	}
+}
+
+func TestPosterUploadETagSurvivesThumbnails(t *testing.T) {
+	app := &application{}
+
+	// The upload responds before the thumbnails have been generated...
+	uploaded := &data.Movie{ID: 1, Version: 5, Poster: data.Poster{Key: "posters/1/a.jpg"}}
+	etag := movieETag(uploaded)
+
+	// ...and they're added later without bumping the version.
+	current := *uploaded
+	current.Poster.Thumbnails = []string{"small", "medium"}
+
+	if movieETag(&current) == etag {
+		t.Error("the entity tag didn't change when the thumbnails were added")
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", nil)
+	r.Header.Set("If-Match", etag)
+
+	rr := httptest.NewRecorder()
+
+	if !app.checkMovieIfMatch(rr, r, &current) {
+		t.Errorf("If-Match with the upload's entity tag failed with status %d", rr.Code)
+	}
+}
@@ -26,20 +26,106 @@ func (app *application) routes() http.Handler {
 	// passing in the required permission code as the first parameter.
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.paramRoutes("id", map[string]http.HandlerFunc{
+		"events": app.requirePermission("movies:read", app.streamMovieEventsHandler),
+		"export": app.requirePermission("movies:read", app.exportMoviesHandler),
+		"facets": app.requirePermission("movies:read", app.listMovieFacetsHandler),
+		"trash":  app.requirePermission("movies:write", app.listTrashedMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.paramRoutes("id", map[string]http.HandlerFunc{
+		"bulk": app.requirePermission("movies:write", app.bulkCreateMoviesHandler),
+	}, app.methodNotAllowedResponse))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/history", app.requirePermission("movies:read", app.showMovieHistoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert/:version", app.requirePermission("movies:write", app.revertMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermission("movies:merge", app.mergeMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCreditHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/titles", app.requirePermission("movies:read", app.listMovieTitlesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/titles", app.requirePermission("movies:write", app.createMovieTitleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/titles/:title_id", app.requirePermission("movies:write", app.deleteMovieTitleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/rating", app.requireActivatedUser(app.showMovieRatingHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requireActivatedUser(app.setMovieRatingHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requireActivatedUser(app.deleteMovieRatingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requireActivatedUser(app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireActivatedUser(app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.deleteMovieReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:write", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission("movies:write", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("movies:write", app.updateGenreHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id/filmography", app.requirePermission("movies:read", app.showPersonFilmographyHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlists", app.requireActivatedUser(app.listWatchlistsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlists", app.requireActivatedUser(app.createWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlists/:id", app.requireActivatedUser(app.showWatchlistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/watchlists/:id", app.requireActivatedUser(app.updateWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlists/:id", app.requireActivatedUser(app.deleteWatchlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlists/:id/items/:movie_id", app.requireActivatedUser(app.setWatchlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlists/:id/items/:movie_id", app.requireActivatedUser(app.deleteWatchlistItemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watchlists/:slug", app.showSharedWatchlistHandler)
 
 	// router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler) // using a session token
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/login", app.createAuthenticationJWTHandler) // using a JWT
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails", app.requirePermission("emails:view", app.listEmailsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:manage", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:manage", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/media/*key", app.serveMediaHandler)
+
 	router.Handler(http.MethodGet, "/debug/metrics", expvar.Handler())
 
 	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticateJWT(router)))))
 }
+
+// httprouter doesn't allow a fixed path segment to share its position with a named
+// parameter, so GET /v1/movies/trash and GET /v1/movies/:id can't both be registered
+// (and neither can POST /v1/movies/bulk and POST /v1/movies/:id/restore).
+// The paramRoutes() helper works around this: we register the parameterized route
+// once, and it dispatches to one of the fixed handlers if the parameter value matches
+// its key, or to the fallback handler otherwise.
+func (app *application) paramRoutes(param string, fixed map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := httprouter.ParamsFromContext(r.Context()).ByName(param)
+
+		if handler, ok := fixed[value]; ok {
+			handler(w, r)
+			return
+		}
+
+		fallback(w, r)
+	}
+}
@@ -113,9 +113,20 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError), // Log only errors
 	}
 
+	// Shutdown() waits for requests to finish, so end any event streams as soon as it's
+	// called.
+	srv.RegisterOnShutdown(app.events.shutdown)
+
 	// Channel for any errors that occur during graceful shutdown
 	shutdownError := make(chan error)
 
+	// Start the long-running background workers, with a context that we cancel when
+	// the server starts shutting down so that they know when to stop
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	app.startWorkers(workersCtx)
+
 	// Run a goroutine that waits for shutdown signals (SIGINT, SIGTERM)
 	go func() {
 		quit := make(chan os.Signal, 1) // Will store OS signals
@@ -139,7 +150,8 @@ func (app *application) serve() error {
 			shutdownError <- err // Send error if shutdown failed
 		}
 
-		// Wait for any background goroutines to finish
+		// Stop the background workers, then wait for any background goroutines to finish
+		stopWorkers()
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 		app.bwg.Wait()
 
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define limits for the similar movies cache. Each entry holds one ranking of at most
+// data.MaxSimilarMovies IDs and scores, however many pages of it are requested.
+// Entries are replaced as soon as their movie is updated, but the other movies can
+// change too (or be added), so entries also expire after a while.
+const (
+	similarCacheSize = 1000
+	similarCacheTTL  = time.Hour
+)
+
+// similarCacheEntry holds the cached ranking of similar movies for one version of a
+// movie.
+type similarCacheEntry struct {
+	version int32
+	created time.Time
+	ranking []data.SimilarityScore
+}
+
+// similarCache caches the ranking of similar movies for each movie until the movie is
+// next updated. Only the IDs and scores are cached; the movies themselves are fetched
+// fresh for each page. It's safe for concurrent use.
+type similarCache struct {
+	mu      sync.Mutex
+	entries map[int64]*similarCacheEntry
+}
+
+func newSimilarCache() *similarCache {
+	return &similarCache{entries: make(map[int64]*similarCacheEntry)}
+}
+
+// get() returns the cached ranking of similar movies for a version of a movie, if
+// there is one.
+func (c *similarCache) get(movie *data.Movie) ([]data.SimilarityScore, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[movie.ID]
+	if !ok || entry.version != movie.Version || time.Since(entry.created) > similarCacheTTL {
+		return nil, false
+	}
+
+	return entry.ranking, true
+}
+
+// set() caches the ranking of similar movies for a version of a movie, replacing the
+// ranking cached for any other version. When the cache is full, the oldest entry is
+// evicted to make room.
+func (c *similarCache) set(movie *data.Movie, ranking []data.SimilarityScore) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[movie.ID]; !ok && len(c.entries) >= similarCacheSize {
+		c.evictOldest()
+	}
+
+	c.entries[movie.ID] = &similarCacheEntry{
+		version: movie.Version,
+		created: time.Now(),
+		ranking: ranking,
+	}
+}
+
+// evictOldest() removes the entry which was created first. The caller must hold the
+// mutex.
+func (c *similarCache) evictOldest() {
+	var (
+		oldestID int64
+		oldest   time.Time
+	)
+
+	for id, entry := range c.entries {
+		if oldest.IsZero() || entry.created.Before(oldest) {
+			oldestID, oldest = id, entry.created
+		}
+	}
+
+	delete(c.entries, oldestID)
+}
+
+// List the movies which are most similar to a movie, best matches first. The ranking
+// for each movie is cached until it's next updated.
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// The results are always ordered by how similar they are.
+	input.Filters.Sort = "-score"
+	input.Filters.SortSafelist = []string{"-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ranking, ok := app.similar.get(movie)
+	if !ok {
+		ranking, err = app.models.Movies.RankSimilar(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.similar.set(movie, ranking)
+	}
+
+	similar, metadata, err := app.models.Movies.GetSimilar(ranking, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"similar": similar, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/travboz/greenlightv3/internal/data"
+)
+
+func TestSimilarCache(t *testing.T) {
+	c := newSimilarCache()
+
+	movie := &data.Movie{ID: 1, Version: 1}
+	ranking := []data.SimilarityScore{{MovieID: 2, Score: 0.9}, {MovieID: 3, Score: 0.5}}
+
+	if _, ok := c.get(movie); ok {
+		t.Fatal("got a ranking from an empty cache")
+	}
+
+	c.set(movie, ranking)
+
+	got, ok := c.get(movie)
+	if !ok || len(got) != 2 {
+		t.Fatalf("got %+v, %t; want the cached ranking", got, ok)
+	}
+
+	// Updating the movie invalidates its cached ranking.
+	updated := &data.Movie{ID: 1, Version: 2}
+
+	if _, ok := c.get(updated); ok {
+		t.Error("got a ranking cached for an earlier version")
+	}
+
+	c.set(updated, ranking[:1])
+
+	if _, ok := c.get(movie); ok {
+		t.Error("got a ranking cached for an earlier version after caching the new version")
+	}
+
+	if len(c.entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(c.entries))
+	}
+}
+
+func TestSimilarCacheEviction(t *testing.T) {
+	c := newSimilarCache()
+
+	for id := int64(1); id <= similarCacheSize+1; id++ {
+		c.set(&data.Movie{ID: id, Version: 1}, nil)
+	}
+
+	if len(c.entries) != similarCacheSize {
+		t.Errorf("got %d entries, want %d", len(c.entries), similarCacheSize)
+	}
+
+	if _, ok := c.get(&data.Movie{ID: similarCacheSize + 1, Version: 1}); !ok {
+		t.Error("the newest entry was evicted")
+	}
+}
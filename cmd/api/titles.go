@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// maxAcceptLanguages limits how many of the languages in an Accept-Language header we
+// try when localizing movie titles.
+const maxAcceptLanguages = 10
+
+// The parseAcceptLanguage() helper returns the language tags from an Accept-Language
+// header, most preferred first. Tags with the same quality value keep the order they
+// were sent in. The "*" wildcard, tags with a quality value of 0 and malformed entries
+// are ignored.
+func parseAcceptLanguage(header string) []string {
+	type language struct {
+		tag string
+		q   float64
+	}
+
+	var languages []language
+
+	for _, entry := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || !data.LocaleRX.MatchString(tag) {
+			continue
+		}
+
+		q := 1.0
+
+		if params = strings.TrimSpace(params); params != "" {
+			value, ok := strings.CutPrefix(params, "q=")
+			if !ok {
+				continue
+			}
+
+			var err error
+
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		if q > 0 {
+			languages = append(languages, language{tag, q})
+		}
+	}
+
+	slices.SortStableFunc(languages, func(a, b language) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	tags := make([]string, 0, min(len(languages), maxAcceptLanguages))
+	for _, language := range languages[:cap(tags)] {
+		tags = append(tags, language.tag)
+	}
+
+	return tags
+}
+
+// The localizeMovies() helper replaces the titles of the movies with their alternate
+// titles in the languages from the request's Accept-Language header, where they have
+// one. It adds Accept-Language to the Vary header, as the response depends on it.
+func (app *application) localizeMovies(w http.ResponseWriter, r *http.Request, movies ...*data.Movie) error {
+	w.Header().Add("Vary", "Accept-Language")
+
+	return app.models.Titles.Localize(movies, parseAcceptLanguage(r.Header.Get("Accept-Language")))
+}
+
+// The checkMovieIfMatch() helper works like checkIfMatch() for a movie. Only the
+// version part of the entity tags is compared (see movieVersionETag()), so changes to
+// the ratings or thumbnails don't fail the precondition. A movie also has a different
+// entity tag when it's been localized, so an If-Match header matches if it holds
+// either the canonical tag, or the one the client would have been sent for the movie
+// with its current Accept-Language header.
+func (app *application) checkMovieIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	header = movieVersionTags(header)
+
+	if etagMatches(header, movieVersionETag(movie), false) {
+		return true
+	}
+
+	// Localize a copy of the movie, so that the caller's movie keeps its canonical
+	// title.
+	localized := *movie
+
+	err := app.models.Titles.Localize([]*data.Movie{&localized}, parseAcceptLanguage(r.Header.Get("Accept-Language")))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if localized.TitleLocale != "" && etagMatches(header, movieVersionETag(&localized), false) {
+		return true
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+func (app *application) listMovieTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check that the movie exists (and isn't in the trash) before listing its titles.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	titles, err := app.models.Titles.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"titles": titles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Locale     string `json:"locale"`
+		Title      string `json:"title"`
+		IsOriginal bool   `json:"is_original"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	title := &data.MovieTitle{
+		MovieID:    id,
+		Locale:     input.Locale,
+		Title:      input.Title,
+		IsOriginal: input.IsOriginal,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieTitle(v, title); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Titles.Insert(title, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateTitle):
+			v.AddError("title", "the movie already has this title in this locale")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateOriginalTitle):
+			v.AddError("is_original", "the movie already has an original title")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"title": title}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	titleID, err := app.readInt64Param(r, "title_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Titles.Delete(id, titleID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "title successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{name: "empty", header: "", want: []string{}},
+		{name: "single", header: "fr", want: []string{"fr"}},
+		{name: "quality order", header: "en;q=0.5, fr-CA, fr;q=0.9", want: []string{"fr-CA", "fr", "en"}},
+		{name: "ties keep order", header: "de;q=0.8, es;q=0.8", want: []string{"de", "es"}},
+		{name: "wildcard and zero", header: "ja, *;q=0.5, en;q=0", want: []string{"ja"}},
+		{name: "malformed", header: "pt-BR;q=abc, en_US, zh-Hant;level=1, it;q=0.3", want: []string{"it"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAcceptLanguage(tt.header); !slices.Equal(got, tt.want) {
+				t.Errorf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
@@ -98,6 +98,39 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// In privacy mode we respond before looking up the user at all, so that neither the
+	// response nor how long it takes reveals whether the email address is registered.
+	// The lookup and the email itself are then handled in the background.
+	if app.config.privacyMode {
+		app.background(func() {
+			user, err := app.models.Users.GetByEmail(input.Email)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					app.logger.Error(err.Error())
+				}
+				return
+			}
+
+			if !user.Activated {
+				return
+			}
+
+			token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+			if err != nil {
+				app.logger.Error(err.Error())
+				return
+			}
+
+			app.sendEmail(user.Email, "token_password_reset.tmpl", map[string]any{
+				"passwordResetToken": token.Plaintext,
+			})
+		})
+
+		app.privacyAcceptedResponse(w, r)
+		return
 	}
 
 	// Try to retrieve the corresponding user record for the email address. If it can't
@@ -138,10 +171,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		// Since email addresses MAY be case sensitive, notice that we are sending this
 		// email using the address stored in our database for the user --- not to the
 		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
+		app.sendEmail(user.Email, "token_password_reset.tmpl", data)
 	})
 
 	// Send a 202 Accepted response and confirmation message to the client.
@@ -172,6 +202,37 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	// As with password resets, in privacy mode we respond straight away and do the
+	// lookup in the background.
+	if app.config.privacyMode {
+		app.background(func() {
+			user, err := app.models.Users.GetByEmail(input.Email)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					app.logger.Error(err.Error())
+				}
+				return
+			}
+
+			if user.Activated {
+				return
+			}
+
+			token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+			if err != nil {
+				app.logger.Error(err.Error())
+				return
+			}
+
+			app.sendEmail(user.Email, "token_activation.tmpl", map[string]any{
+				"activationToken": token.Plaintext,
+			})
+		})
+
+		app.privacyAcceptedResponse(w, r)
+		return
+	}
+
 	// Try to retrieve the corresponding user record for the email address. If it can't
 	// be found, return an error message to the client.
 	user, err := app.models.Users.GetByEmail(input.Email)
@@ -209,10 +270,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		// Since email addresses MAY be case sensitive, notice that we are sending this
 		// email using the address stored in our database for the user --- not to the
 		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
+		app.sendEmail(user.Email, "token_activation.tmpl", data)
 	})
 
 	// Send a 202 Accepted response and confirmation message to the client.
@@ -257,6 +315,9 @@ func (app *application) createAuthenticationJWTHandler(w http.ResponseWriter, r
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// Run a dummy bcrypt comparison so that this response takes as long as it
+			// would for a registered email address with the wrong password.
+			data.CompareDummyPassword(payload.Password)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A fakeUser is a registered account known to fakeUserDB.
+type fakeUser struct {
+	id        int64
+	email     string
+	hash      []byte
+	activated bool
+}
+
+// fakeUserDB stands in for the database in the token endpoint tests. It answers user
+// lookups by email address (ignoring case, like the citext column does) and records
+// each lookup and each token that's inserted. Any other query finds no rows.
+type fakeUserDB struct {
+	users []fakeUser
+
+	mu      sync.Mutex
+	lookups []string
+	tokens  []int64 // The user ID of each token inserted.
+}
+
+func (db *fakeUserDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+func (db *fakeUserDB) Driver() driver.Driver {
+	return fakeDriver{db}
+}
+
+type fakeDriver struct {
+	db *fakeUserDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeUserDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fakeUserDB: transactions aren't supported")
+}
+
+type fakeStmt struct {
+	db    *fakeUserDB
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if strings.Contains(s.query, "INSERT INTO tokens") {
+		s.db.tokens = append(s.db.tokens, args[1].(int64))
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &fakeRows{}
+
+	if !strings.Contains(s.query, "FROM users") {
+		return rows, nil
+	}
+
+	email := args[0].(string)
+
+	s.db.mu.Lock()
+	s.db.lookups = append(s.db.lookups, email)
+	s.db.mu.Unlock()
+
+	for _, u := range s.db.users {
+		if strings.EqualFold(u.email, email) {
+			rows.values = append(rows.values, []driver.Value{u.id, time.Now(), "Test User", u.email, u.hash, u.activated, int64(1)})
+		}
+	}
+
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+// newPrivacyTestApp returns an application in privacy mode whose models use a
+// fakeUserDB with two accounts: alice@example.com, which hasn't been activated, and
+// bob@example.com, which has. Both have the password "pa55word". Emails can't be sent,
+// which is logged and is fine --- the tests only look at the lookups and tokens.
+func newPrivacyTestApp(t *testing.T) (*application, *fakeUserDB) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa55word"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeUserDB{
+		users: []fakeUser{
+			{id: 1, email: "alice@example.com", hash: hash, activated: false},
+			{id: 2, email: "bob@example.com", hash: hash, activated: true},
+		},
+	}
+
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	app := newTestApp()
+	app.config.privacyMode = true
+	app.models = data.NewModels(db)
+
+	return app, fake
+}
+
+func TestTokenEndpointsResponseParity(t *testing.T) {
+	emails := []string{
+		"alice@example.com",
+		"bob@example.com",
+		"nobody-at-all@example.com",
+		"ALICE@EXAMPLE.COM",
+	}
+
+	tests := []struct {
+		name       string
+		handler    func(app *application) http.HandlerFunc
+		wantTokens []int64
+	}{
+		{
+			name:       "activation",
+			handler:    func(app *application) http.HandlerFunc { return app.createActivationTokenHandler },
+			wantTokens: []int64{1, 1},
+		},
+		{
+			name:       "password reset",
+			handler:    func(app *application) http.HandlerFunc { return app.createPasswordResetTokenHandler },
+			wantTokens: []int64{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake := newPrivacyTestApp(t)
+			handler := tt.handler(app)
+
+			var (
+				wantStatus int
+				wantBody   string
+				wantHeader http.Header
+			)
+
+			for i, email := range emails {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email": "`+email+`"}`))
+
+				handler(rr, req)
+
+				res := rr.Result()
+				body, err := io.ReadAll(res.Body)
+				res.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if res.StatusCode != http.StatusAccepted {
+					t.Errorf("%s: wanted status %d, got %d", email, http.StatusAccepted, res.StatusCode)
+				}
+
+				if i == 0 {
+					wantStatus, wantBody, wantHeader = res.StatusCode, string(body), res.Header
+					continue
+				}
+
+				if res.StatusCode != wantStatus {
+					t.Errorf("%s: status %d differs from %d", email, res.StatusCode, wantStatus)
+				}
+
+				if string(body) != wantBody {
+					t.Errorf("%s: body %q differs from %q", email, body, wantBody)
+				}
+
+				for key := range wantHeader {
+					if res.Header.Get(key) != wantHeader.Get(key) {
+						t.Errorf("%s: header %s = %q, differs from %q", email, key, res.Header.Get(key), wantHeader.Get(key))
+					}
+				}
+			}
+
+			app.bwg.Wait()
+
+			// The responses were the same, but the work done in the background wasn't:
+			// every address was looked up, and only the accounts which need a token got
+			// one.
+			if len(fake.lookups) != len(emails) {
+				t.Errorf("got %d lookups, want %d", len(fake.lookups), len(emails))
+			}
+
+			if !slices.Equal(fake.tokens, tt.wantTokens) {
+				t.Errorf("got tokens for users %v, want %v", fake.tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+// A failed login for an address which isn't registered runs a bcrypt comparison
+// against a dummy hash, so it responds in the same way as a wrong password for an
+// existing account, and takes about as long.
+func TestLoginResponseParity(t *testing.T) {
+	app, _ := newPrivacyTestApp(t)
+
+	login := func(email string) (int, string, time.Duration) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email": "`+email+`", "password": "wrong-password"}`))
+
+		start := time.Now()
+		app.createAuthenticationJWTHandler(rr, req)
+		elapsed := time.Since(start)
+
+		return rr.Code, rr.Body.String(), elapsed
+	}
+
+	existingStatus, existingBody, existingTime := login("alice@example.com")
+	missingStatus, missingBody, missingTime := login("nobody-at-all@example.com")
+
+	if existingStatus != http.StatusUnauthorized || missingStatus != http.StatusUnauthorized {
+		t.Errorf("got statuses %d and %d, want %d", existingStatus, missingStatus, http.StatusUnauthorized)
+	}
+
+	if missingBody != existingBody {
+		t.Errorf("body %q for a missing account differs from %q", missingBody, existingBody)
+	}
+
+	// Without the dummy comparison the missing account would respond in microseconds,
+	// rather than taking as long as a bcrypt comparison. The bound is loose so that
+	// the test isn't flaky on a busy machine.
+	if missingTime < existingTime/4 {
+		t.Errorf("a missing account took %s, much less than the %s an existing one took", missingTime, existingTime)
+	}
+}
+
+func TestTokenEndpointsStillValidateEmail(t *testing.T) {
+	app, _ := newPrivacyTestApp(t)
+
+	for _, handler := range []http.HandlerFunc{app.createActivationTokenHandler, app.createPasswordResetTokenHandler} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email": "not-an-email"}`))
+
+		handler(rr, req)
+
+		if got := rr.Result().StatusCode; got != http.StatusUnprocessableEntity {
+			t.Errorf("wanted status %d, got %d", http.StatusUnprocessableEntity, got)
+		}
+	}
+
+	app.bwg.Wait()
+}
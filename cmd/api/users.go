@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/travboz/greenlightv3/internal/data"
@@ -94,10 +95,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 
 		// Send the welcome email, passing in the map above as dynamic data.
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
+		app.sendEmail(user.Email, "user_welcome.tmpl", data)
 	})
 
 	// Write a JSON response containing the user data along with a 201 Created status
@@ -249,3 +247,421 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Show the account details of the current user, along with the permissions that they
+// have been granted.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Make sure that a user with no permissions gets an empty JSON array, not null.
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the current user's name and/or password, and start changing their email
+// address. Name and password changes are saved straight away (changing the password
+// requires the current password too). The email address isn't updated straight away;
+// instead we email a confirmation token to the new address and a notice to the old
+// one, and only swap the address over once the token is confirmed.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name == nil && input.Email == nil && input.Password == nil {
+		v.AddError("body", "must contain at least one of name, email or password")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Password != nil {
+		// Require the current password before letting the user set a new one, so that
+		// a stolen authentication token isn't enough to take over the account.
+		if input.CurrentPassword == nil {
+			v.AddError("current_password", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		match, err := user.Password.Matches(*input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !match {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if input.Email != nil {
+		data.ValidateEmail(v, *input.Email)
+		v.Check(!strings.EqualFold(*input.Email, user.Email), "email", "must be different from your current email address")
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Name != nil || input.Password != nil {
+		// The user record was read when the request was authenticated, so Update()
+		// will return an edit conflict if it has been changed since then.
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Any outstanding password reset tokens were issued for the old password, so
+		// we remove them.
+		if input.Password != nil {
+			err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if input.Email == nil {
+		err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the most recently requested change should be confirmable, so remove any
+	// outstanding email change tokens before creating a new one.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.NewEmailChange(user.ID, 24*time.Hour, *input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		app.sendEmail(token.Email, "token_email_change.tmpl", map[string]any{
+			"emailChangeToken": token.Plaintext,
+		})
+
+		app.sendEmail(user.Email, "user_email_change_notice.tmpl", map[string]any{
+			"newEmail": token.Email,
+		})
+	})
+
+	env := envelope{"message": "an email will be sent to your new address containing confirmation instructions"}
+
+	// If the name or password were changed as well, include the updated user.
+	if input.Name != nil || input.Password != nil {
+		env["user"] = user
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete the current user's account. Their tokens and permissions are removed by the
+// database along with the user record.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account was successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Verify an email change token and switch the user over to their new email address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, newEmail, err := app.models.Users.GetForEmailChangeToken(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Email = newEmail
+
+	// Another account may have registered with (or switched to) the new address since
+	// the change was requested, so we check for a duplicate email here rather than
+	// when the token was created.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The admin user handlers below let operators with the "users:admin" permission list,
+// inspect, update and delete other users' accounts.
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email     string
+		Name      string
+		Activated *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Email = app.readString(qs, "email", "")
+	input.Name = app.readString(qs, "name", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Email, input.Name, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update another user's name, email address or activation status. Unlike the
+// self-service endpoint, email changes made by an operator take effect straight away.
+// Setting "activated" to false deactivates the account.
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Email     *string `json:"email"`
+		Activated *bool   `json:"activated"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Email != nil {
+		user.Email = *input.Email
+	}
+
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Users.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data"
+	"github.com/travboz/greenlightv3/internal/webhook"
+)
+
+// webhookBatchSize is the most webhook deliveries that are attempted at once.
+const webhookBatchSize = 20
+
+// The startWorkers() method launches the long-running background jobs for the
+// application. Each one runs via app.background(), so it is tracked by the WaitGroup,
+// and returns once the given context is cancelled when the server shuts down.
+func (app *application) startWorkers(ctx context.Context) {
+	app.background(func() {
+		app.purgeTrashedMovies(ctx)
+	})
+
+	app.background(func() {
+		app.deliverWebhooks(ctx)
+	})
+
+	app.background(func() {
+		app.listenForMovieEvents(ctx)
+	})
+}
+
+// The purgeTrashedMovies() method permanently deletes movies which have been in the
+// trash for longer than the configured retention period, along with their posters. It
+// runs once straight away and then again every purge interval, until the context is
+// cancelled.
+func (app *application) purgeTrashedMovies(ctx context.Context) {
+	retention := time.Duration(app.config.trash.retentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(app.config.trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, posters, err := app.models.Movies.PurgeTrashed(retention)
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if purged > 0 {
+			app.logger.Info("purged trashed movies", "count", purged)
+		}
+
+		// The movies are gone, so their posters and thumbnails can go too.
+		for _, key := range posters {
+			app.deletePoster(key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// The deliverWebhooks() method attempts the webhook deliveries which are due, every
+// poll interval until the context is cancelled. Failed deliveries are retried with
+// exponential backoff, up to the configured number of attempts. Deliveries are claimed
+// in the database, so it's safe to run more than one instance of the application.
+func (app *application) deliverWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(app.config.webhooks.pollInterval)
+	defer ticker.Stop()
+
+	// Each claimed delivery is left alone by other workers for long enough to finish
+	// the attempt and record its outcome.
+	lease := app.config.webhooks.timeout + time.Minute
+
+	for {
+		// Keep going while there are full batches, so that a backlog is cleared
+		// without waiting for the ticker.
+		for {
+			deliveries, err := app.models.Webhooks.ClaimDue(webhookBatchSize, lease)
+			if err != nil {
+				app.logger.Error(err.Error())
+				break
+			}
+
+			var wg sync.WaitGroup
+
+			for _, delivery := range deliveries {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					app.deliverWebhook(ctx, delivery)
+				}()
+			}
+
+			wg.Wait()
+
+			if len(deliveries) < webhookBatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// The deliverWebhook() method makes one attempt at a claimed delivery and records the
+// outcome. If the attempt is cut short because the server is shutting down, nothing is
+// recorded, and the delivery is retried once its lease runs out.
+func (app *application) deliverWebhook(ctx context.Context, delivery *data.DueDelivery) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	status, err := app.webhook.Send(ctx, webhook.Message{
+		URL:       delivery.URL,
+		Secret:    delivery.Secret,
+		EventID:   delivery.Event.ID,
+		EventType: delivery.Event.Type,
+		Body:      body,
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.NextAttemptAt = nil
+	delivery.Error = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = data.DeliveryStatusDelivered
+	case delivery.Attempts >= app.config.webhooks.maxAttempts:
+		delivery.Status = data.DeliveryStatusFailed
+		delivery.Error = err.Error()
+	default:
+		next := time.Now().Add(webhook.Backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		delivery.Error = err.Error()
+	}
+
+	err = app.models.Webhooks.UpdateDelivery(&delivery.WebhookDelivery)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	if delivery.Status == data.DeliveryStatusFailed {
+		app.logger.Warn("webhook delivery failed", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID, "attempts", delivery.Attempts, "error", delivery.Error)
+	}
+}
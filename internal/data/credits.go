@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define a custom ErrDuplicateCredit error.
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// CreditRoles holds the roles a person can be credited with. A movie's credits are
+// listed in this order, with the cast first.
+var CreditRoles = []string{"actor", "director", "writer", "producer", "composer", "cinematographer", "editor"}
+
+// A Credit links a person to a movie. Character is only set for actors, and Billing
+// orders the credits within a role (lowest first). When listing a movie's credits the
+// Person is filled in, and when listing a person's filmography the Movie is.
+type Credit struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	PersonID  int64     `json:"person_id"`
+	Role      string    `json:"role"`
+	Character string    `json:"character,omitempty"`
+	Billing   int32     `json:"billing"`
+	Person    *Person   `json:"person,omitempty"`
+	Movie     *Movie    `json:"movie,omitempty"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID != 0, "person_id", "must be provided")
+	v.Check(credit.PersonID > 0, "person_id", "must be a positive integer")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoles...), "role", "must be a known role")
+
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+
+	v.Check(credit.Billing >= 0, "billing", "must not be negative")
+}
+
+// Define a CreditModel struct which wraps the connection pool.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+	INSERT INTO movie_credits (movie_id, person_id, role, character, billing)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.Billing}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForMovie() returns every credit on a movie along with the person credited,
+// ordered by role and then by billing.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+	SELECT c.id, c.created_at, c.movie_id, c.person_id, c.role, c.character, c.billing, p.id, p.name, p.version
+	FROM movie_credits c
+	INNER JOIN people p ON p.id = c.person_id
+	WHERE c.movie_id = $1
+	ORDER BY array_position($2, c.role), c.billing, p.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, pq.Array(CreditRoles))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		credit := Credit{Person: &Person{}}
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.CreatedAt,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.Billing,
+			&credit.Person.ID,
+			&credit.Person.Name,
+			&credit.Person.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetFilmography() returns a page of a person's credits along with the movie for each
+// one. Movies in the trash are left out.
+func (m CreditModel) GetFilmography(personID int64, filters Filters) ([]*Credit, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), c.id, c.created_at, c.movie_id, c.person_id, c.role, c.character, c.billing, m.id, m.title, m.year, m.version
+	FROM movie_credits c
+	INNER JOIN movies m ON m.id = c.movie_id
+	WHERE c.person_id = $1 AND m.deleted_at IS NULL
+	ORDER BY %s %s, c.id ASC
+	LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	credits := []*Credit{}
+
+	for rows.Next() {
+		credit := Credit{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&credit.ID,
+			&credit.CreatedAt,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.Billing,
+			&credit.Movie.ID,
+			&credit.Movie.Title,
+			&credit.Movie.Year,
+			&credit.Movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return credits, metadata, nil
+}
+
+// Delete() removes a credit from a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM movie_credits
+	WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define constants for the delivery status of an email.
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+// The Email struct is an audit record of a single outbound email. Attempts holds the
+// number of times we tried to deliver it to the SMTP server, and Error holds the
+// message from the final failed attempt (if any).
+type Email struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Recipient string    `json:"recipient"`
+	Template  string    `json:"template"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+}
+
+// Check that the status filter (if provided) is one of our known statuses.
+func ValidateEmailStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, "", EmailStatusPending, EmailStatusSent, EmailStatusFailed), "status", "invalid status value")
+}
+
+// Define an EmailModel struct which wraps the connection pool.
+type EmailModel struct {
+	DB *sql.DB
+}
+
+// Insert a new audit record for an email which is about to be sent.
+func (m EmailModel) Insert(email *Email) error {
+	query := `
+		INSERT INTO emails (recipient, template, status)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at;`
+
+	args := []any{email.Recipient, email.Template, email.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&email.ID, &email.CreatedAt, &email.UpdatedAt)
+}
+
+// Update the delivery status, error and attempt count for an email once the mailer has
+// finished with it.
+func (m EmailModel) Update(email *Email) error {
+	query := `
+		UPDATE emails
+		SET status = $1, error = $2, attempts = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING updated_at;`
+
+	args := []any{email.Status, email.Error, email.Attempts, email.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&email.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAll() returns a page of email audit records, optionally filtered by recipient,
+// template and status. Empty filter values match everything.
+func (m EmailModel) GetAll(recipient, template, status string, filters Filters) ([]*Email, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, updated_at, recipient, template, status, error, attempts
+		FROM emails
+		WHERE (recipient = $1 OR $1 = '')
+		AND (template = $2 OR $2 = '')
+		AND (status = $3 OR $3 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	args := []any{recipient, template, status, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*Email{}
+
+	for rows.Next() {
+		var email Email
+
+		err := rows.Scan(
+			&totalRecords,
+			&email.ID,
+			&email.CreatedAt,
+			&email.UpdatedAt,
+			&email.Recipient,
+			&email.Template,
+			&email.Status,
+			&email.Error,
+			&email.Attempts,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return emails, metadata, nil
+}
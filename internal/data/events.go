@@ -0,0 +1,219 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MovieEventsChannel is the channel that Postgres notifies with the ID of each new
+// movie event, once the transaction which recorded it has committed. The notifications
+// only say that there's something new to read; see MovieEventModel.GetAfter() for how
+// events are read in order.
+const MovieEventsChannel = "movie_events"
+
+// Define constants for the types of movie event.
+const (
+	EventMovieCreated = "movie.created"
+	EventMovieUpdated = "movie.updated"
+	EventMovieDeleted = "movie.deleted"
+)
+
+// EventTypes holds every type of movie event, in the order they're documented.
+var EventTypes = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted}
+
+// A MovieEvent records a change to a movie in the movie_events outbox, from where it's
+// delivered to webhooks. Snapshot holds the movie's editable fields after the change,
+// in the same shape as MovieSnapshot, and Changes holds the fields which changed, in
+// the same shape as a revision's changes.
+type MovieEvent struct {
+	ID        int64           `json:"id"`
+	TxID      int64           `json:"-"` // The ID of the transaction which recorded the event.
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	MovieID   int64           `json:"movie_id"`
+	Version   int32           `json:"version"`
+	Snapshot  json.RawMessage `json:"snapshot"`
+	Changes   json.RawMessage `json:"changes"`
+}
+
+// Position() returns where the event falls in the order that events are read in.
+func (e *MovieEvent) Position() EventPosition {
+	return EventPosition{TxID: e.TxID, ID: e.ID}
+}
+
+// An EventPosition is a place in the sequence of movie events, which are ordered by
+// the ID of the transaction which recorded them and then by their own ID. Unlike the
+// event IDs alone, which are handed out as events are inserted rather than as they're
+// committed, this order can't have an event committed behind a position that's
+// already been read (see GetAfter()). The zero value is the start of the sequence.
+type EventPosition struct {
+	TxID int64
+	ID   int64
+}
+
+// After() reports whether p comes after q.
+func (p EventPosition) After(q EventPosition) bool {
+	return p.TxID > q.TxID || (p.TxID == q.TxID && p.ID > q.ID)
+}
+
+// String() formats the position as "<txid>-<id>", which is how clients see it.
+func (p EventPosition) String() string {
+	return fmt.Sprintf("%d-%d", p.TxID, p.ID)
+}
+
+// ParseEventPosition() parses a position in the format returned by String().
+func ParseEventPosition(s string) (EventPosition, error) {
+	txid, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return EventPosition{}, fmt.Errorf("invalid event position %q", s)
+	}
+
+	var (
+		p   EventPosition
+		err error
+	)
+
+	p.TxID, err = strconv.ParseInt(txid, 10, 64)
+	if err != nil || p.TxID < 0 {
+		return EventPosition{}, fmt.Errorf("invalid event position %q", s)
+	}
+
+	p.ID, err = strconv.ParseInt(id, 10, 64)
+	if err != nil || p.ID < 0 {
+		return EventPosition{}, fmt.Errorf("invalid event position %q", s)
+	}
+
+	return p, nil
+}
+
+// movieEventType returns the type of event to record for a revision action. Moving a
+// movie to the trash (including by merging it into another movie) counts as deleting
+// it, and restoring or reverting it counts as an update.
+func movieEventType(action string) string {
+	switch action {
+	case RevisionActionInsert:
+		return EventMovieCreated
+	case RevisionActionDelete, RevisionActionMerge:
+		return EventMovieDeleted
+	default:
+		return EventMovieUpdated
+	}
+}
+
+// fanOutMovieEvents is the tail of a statement which queues a delivery of each event
+// in the "events" CTE to every active webhook that's subscribed to its type. The CTE
+// must return the id and type of each event.
+const fanOutMovieEvents = `
+	INSERT INTO webhook_deliveries (webhook_id, event_id)
+	SELECT w.id, e.id
+	FROM events e
+	INNER JOIN webhooks w ON w.active AND (cardinality(w.events) = 0 OR e.type = ANY(w.events))`
+
+// insertMovieEvent records an event for a revision of a movie as part of an existing
+// transaction, and queues its webhook deliveries. Like the revision, the event is only
+// saved if the change to the movie is.
+func insertMovieEvent(ctx context.Context, tx *sql.Tx, movie *Movie, action string, snapshot, changes []byte) error {
+	query := `
+	WITH events AS (
+		INSERT INTO movie_events (movie_id, version, type, snapshot, changes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, type
+	)` + fanOutMovieEvents
+
+	args := []any{movie.ID, movie.Version, movieEventType(action), snapshot, changes}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Define a MovieEventModel struct which wraps the connection pool.
+type MovieEventModel struct {
+	DB *sql.DB
+}
+
+// readableMovieEvents is the condition which GetAfter() and Latest() use to only read
+// the events recorded by transactions older than every transaction that's still in
+// flight. A transaction which commits later has a higher transaction ID than those, so
+// it can't add an event behind one that's been read. The flip side is that events are
+// held back while any older transaction (including ones which don't touch movies) is
+// still running.
+const readableMovieEvents = `txid < pg_snapshot_xmin(pg_current_snapshot())`
+
+// GetAfter() returns up to limit of the movie events which come after the given
+// position, in order. A reader which keeps track of the position of the last event it
+// got never misses an event by resuming from it.
+func (m MovieEventModel) GetAfter(after EventPosition, limit int) ([]*MovieEvent, error) {
+	query := `
+	SELECT id, txid, type, created_at, movie_id, version, snapshot, changes
+	FROM movie_events
+	WHERE (txid, id) > ($1::xid8, $2) AND ` + readableMovieEvents + `
+	ORDER BY txid, id
+	LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, after.TxID, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*MovieEvent{}
+
+	for rows.Next() {
+		var event MovieEvent
+
+		err := rows.Scan(
+			&event.ID,
+			&event.TxID,
+			&event.Type,
+			&event.CreatedAt,
+			&event.MovieID,
+			&event.Version,
+			&event.Snapshot,
+			&event.Changes,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Latest() returns the position of the last movie event which GetAfter() can read,
+// or the zero position if there isn't one.
+func (m MovieEventModel) Latest() (EventPosition, error) {
+	query := `
+	SELECT txid, id
+	FROM movie_events
+	WHERE ` + readableMovieEvents + `
+	ORDER BY txid DESC, id DESC
+	LIMIT 1`
+
+	var p EventPosition
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&p.TxID, &p.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return EventPosition{}, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A FacetCount is the number of matching movies with a given facet value. Min and Max
+// give the range covered by a decade or runtime bucket, in the same units as the
+// year_min/year_max and runtime_min/runtime_max filters; Max is nil for the last
+// runtime bucket, which has no upper bound.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	Min   *int   `json:"min,omitempty"`
+	Max   *int   `json:"max,omitempty"`
+}
+
+// MovieFacets holds the facet counts for a movie search. Genres are ordered by count,
+// most common first, and the decades and runtime buckets are in ascending order.
+type MovieFacets struct {
+	Genres   []FacetCount `json:"genres"`
+	Decades  []FacetCount `json:"decades"`
+	Runtimes []FacetCount `json:"runtimes"`
+}
+
+// runtimeBuckets holds the lower bounds of the runtime buckets, in minutes. Each
+// bucket runs up to the next bound, and the last one has no upper bound.
+var runtimeBuckets = []int{0, 90, 120, 150}
+
+// GetFacets() returns the facet counts for the movies which match the search. All
+// three facets are calculated by a single query.
+func (m MovieModel) GetFacets(search MovieSearch) (*MovieFacets, error) {
+	where, _ := search.where()
+
+	// Each row holds the facet, a position used to order the rows within the facet (the
+	// decade or the bucket's lower bound), the genre for the genre facet, and the count.
+	query := fmt.Sprintf(`
+	WITH matches AS (
+		SELECT genres, year, runtime
+		FROM movies
+		WHERE %s
+	)
+	SELECT 'genre', 0, genre, count(*)
+	FROM matches, unnest(genres) AS genre
+	GROUP BY genre
+	UNION ALL
+	SELECT 'decade', year / 10 * 10, '', count(*)
+	FROM matches
+	GROUP BY year / 10 * 10
+	UNION ALL
+	SELECT 'runtime', %s, '', count(*)
+	FROM matches
+	GROUP BY 2
+	ORDER BY 1, 2, 4 DESC, 3`,
+		where, runtimeBucketSQL(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	facets := &MovieFacets{
+		Genres:   []FacetCount{},
+		Decades:  []FacetCount{},
+		Runtimes: []FacetCount{},
+	}
+
+	for rows.Next() {
+		var (
+			facet    string
+			position int
+			genre    string
+			count    int
+		)
+
+		err := rows.Scan(&facet, &position, &genre, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		switch facet {
+		case "genre":
+			facets.Genres = append(facets.Genres, FacetCount{Value: genre, Count: count})
+		case "decade":
+			decadeEnd := position + 9
+			facets.Decades = append(facets.Decades, FacetCount{
+				Value: fmt.Sprintf("%ds", position),
+				Count: count,
+				Min:   &position,
+				Max:   &decadeEnd,
+			})
+		case "runtime":
+			facets.Runtimes = append(facets.Runtimes, runtimeFacet(position, count))
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// runtimeBucketSQL returns a CASE expression which gives the lower bound of the
+// runtime bucket that each movie falls into.
+func runtimeBucketSQL() string {
+	var b strings.Builder
+
+	b.WriteString("CASE")
+
+	for i := len(runtimeBuckets) - 1; i > 0; i-- {
+		fmt.Fprintf(&b, " WHEN runtime >= %[1]d THEN %[1]d", runtimeBuckets[i])
+	}
+
+	fmt.Fprintf(&b, " ELSE %d END", runtimeBuckets[0])
+
+	return b.String()
+}
+
+// runtimeFacet returns the facet count for the runtime bucket starting at lower.
+func runtimeFacet(lower, count int) FacetCount {
+	for i, bound := range runtimeBuckets[:len(runtimeBuckets)-1] {
+		if bound == lower {
+			upper := runtimeBuckets[i+1] - 1
+			return FacetCount{Value: fmt.Sprintf("%d-%d mins", lower, upper), Count: count, Min: &lower, Max: &upper}
+		}
+	}
+
+	return FacetCount{Value: fmt.Sprintf("%d+ mins", lower), Count: count, Min: &lower}
+}
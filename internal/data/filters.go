@@ -1,6 +1,8 @@
 package data
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/travboz/greenlightv3/internal/data/validator"
@@ -24,6 +26,18 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 }
 
+// ValidateFields() checks that every field in a sparse fieldset is in the safelist.
+func ValidateFields(v *validator.Validator, fields []string, safelist []string) {
+	for _, field := range fields {
+		if !validator.PermittedValue(field, safelist...) {
+			v.AddError("fields", fmt.Sprintf("invalid field %q", field))
+			break
+		}
+	}
+
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
 // Check that the client-provided Sort field matches one of the entries in our safelist
 // and if it does, extract the column name from the Sort field by stripping the leading
 // hyphen character (if one exists).
@@ -54,6 +68,35 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// A whereClause builds up the conditions of a WHERE clause, and the arguments for them,
+// so that optional conditions can be combined without interpolating any values into
+// the SQL. Conditions refer to their arguments using the placeholders returned by
+// arg().
+type whereClause struct {
+	conditions []string
+	args       []any
+}
+
+// arg() adds an argument and returns its placeholder, e.g. "$3".
+func (w *whereClause) arg(value any) string {
+	w.args = append(w.args, value)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// add() adds a condition, which all rows must match.
+func (w *whereClause) add(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+// String() returns the conditions joined with AND, or TRUE if there aren't any.
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return "TRUE"
+	}
+
+	return strings.Join(w.conditions, "\n\tAND ")
+}
+
 // count(*) OVER()
 
 // Define a new Metadata struct for holding the pagination metadata.
@@ -0,0 +1,303 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define a custom ErrDuplicateSlug error.
+var ErrDuplicateSlug = errors.New("duplicate slug")
+
+// SlugRX matches genre slugs: lowercase letters and digits, in words separated by
+// single hyphens.
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// A Genre is an entry in the genre taxonomy. Movies refer to genres by their slug,
+// and the name is used for display.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+// Slugify() converts a display name into a slug, in the same way as the migration
+// which created the taxonomy, so "Science Fiction" becomes "science-fiction".
+func Slugify(name string) string {
+	var b strings.Builder
+
+	hyphen := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+
+		hyphen = true
+	}
+
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// Define a GenreModel struct which wraps the connection pool.
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new genre to the taxonomy.
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+	INSERT INTO genres (slug, name)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, slug, name, version
+	FROM genres
+	WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// GetAll() returns a page of the genres in the taxonomy.
+func (m GenreModel) GetAll(filters Filters) ([]*Genre, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, slug, name, version
+	FROM genres
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&totalRecords,
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return genres, metadata, nil
+}
+
+// Slugs() returns the slugs of every genre in the taxonomy, for validating the genres
+// of movies with ValidateMovie().
+func (m GenreModel) Slugs() ([]string, error) {
+	query := `SELECT array_agg(slug ORDER BY slug) FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	var slugs []string
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(pq.Array(&slugs))
+	if err != nil {
+		return nil, err
+	}
+
+	return slugs, nil
+}
+
+// Update() saves changes to a genre, using optimistic locking on the version number.
+// If the slug has changed, every movie (including those in the trash) which refers to
+// the old slug is updated to use the new one in the same transaction, and the change
+// is recorded in the movie's history as made by the user with the given ID.
+func (m GenreModel) Update(genre *Genre, oldSlug string, userID int64) error {
+	query := `
+	UPDATE genres
+	SET slug = $1, name = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	args := []any{genre.Slug, genre.Name, genre.ID, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	if genre.Slug != oldSlug {
+		err = renameMovieGenre(ctx, tx, oldSlug, genre.Slug, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// renameMovieGenre replaces a genre slug in every movie which has it, as part of an
+// existing transaction, and records a revision for each of the movies.
+func renameMovieGenre(ctx context.Context, tx *sql.Tx, oldSlug, newSlug string, userID int64) error {
+	columns, _ := movieColumns(&Movie{}, nil)
+
+	query := `
+	UPDATE movies
+	SET genres = array_replace(genres, $1, $2), version = version + 1
+	WHERE genres @> ARRAY[$1]
+	RETURNING ` + columns
+
+	rows, err := tx.QueryContext(ctx, query, oldSlug, newSlug)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	// Read all of the updated movies before recording their revisions, as we can't run
+	// another statement in the transaction until the rows have been closed.
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movieColumns(&movie, nil)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	for _, movie := range movies {
+		before := make([]string, len(movie.Genres))
+		for i, genre := range movie.Genres {
+			before[i] = genre
+			if genre == newSlug {
+				before[i] = oldSlug
+			}
+		}
+
+		changes := map[string]FieldChange{
+			"genres": {From: before, To: movie.Genres},
+		}
+
+		err = insertMovieRevision(ctx, tx, movie, RevisionActionUpdate, userID, changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -15,19 +15,41 @@ var (
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
+	Credits     CreditModel
+	Emails      EmailModel
+	Events      MovieEventModel
+	Genres      GenreModel
 	Movies      MovieModel
+	People      PersonModel
 	Permissions PermissionModel
+	Ratings     RatingModel
+	Reviews     ReviewModel
+	Revisions   RevisionModel
+	Titles      MovieTitleModel
 	Tokens      TokenModel
 	Users       UserModel
+	Watchlists  WatchlistModel
+	Webhooks    WebhookModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
+		Credits:     CreditModel{DB: db},
+		Emails:      EmailModel{DB: db},
+		Events:      MovieEventModel{DB: db},
+		Genres:      GenreModel{DB: db},
 		Movies:      MovieModel{DB: db},
+		People:      PersonModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Ratings:     RatingModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Revisions:   RevisionModel{DB: db},
+		Titles:      MovieTitleModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Users:       UserModel{DB: db},
+		Watchlists:  WatchlistModel{DB: db},
+		Webhooks:    WebhookModel{DB: db},
 	}
 }
@@ -5,33 +5,49 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/travboz/greenlightv3/internal/data/validator"
 )
 
 var (
-	DatabaseTimeout             = 3 * time.Second
-	FullTextSearchEnglishConfig = `
-	SELECT id, created_at, title, year, runtime, genres, version
-	FROM movies
-	WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY id;`
+	DatabaseTimeout = 3 * time.Second
+	BulkTimeout     = time.Minute
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`                // Unique integer ID for the movie
-	CreatedAt time.Time `json:"-"`                 // Timestamp for when the movie is added to our database; hide it so it doesn't show in the json output
-	Title     string    `json:"title"`             // Movie title
-	Year      int32     `json:"year,omitempty"`    // Movie release year; omit if empty
-	Runtime   Runtime   `json:"runtime,omitempty"` // Movie runtime (in minutes); omit if empty
-	Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.); omit if empty
-	Version   int32     `json:"version"`           // The version number starts at 1 and will be incremented each time the movie information is updated
+	ID        int64      `json:"id"`                   // Unique integer ID for the movie
+	CreatedAt time.Time  `json:"-"`                    // Timestamp for when the movie is added to our database; hide it so it doesn't show in the json output
+	Title     string     `json:"title"`                // Movie title
+	Year      int32      `json:"year,omitempty"`       // Movie release year; omit if empty
+	Runtime   Runtime    `json:"runtime,omitempty"`    // Movie runtime (in minutes); omit if empty
+	Genres    []string   `json:"genres,omitempty"`     // Slice of genres for the movie (romance, comedy, etc.); omit if empty
+	Version   int32      `json:"version"`              // The version number starts at 1 and will be incremented each time the movie information is updated
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // Timestamp for when the movie was moved to the trash; nil for live movies
+
+	ImdbID   string   `json:"imdb_id,omitempty"`  // IMDb title ID, such as "tt0111161"; omit if unknown
+	TmdbID   int64    `json:"tmdb_id,omitempty"`  // The Movie Database ID; omit if unknown
+	Releases Releases `json:"releases,omitempty"` // Release dates and age certifications by country; omit if empty
+
+	// The rating aggregates are kept up to date by a trigger on the ratings table, so
+	// they change without the version number being bumped.
+	AverageRating float64 `json:"average_rating,omitempty"` // Average of the users' ratings (1-10), to 2 decimal places; omit if unrated
+	RatingCount   int32   `json:"rating_count,omitempty"`   // Number of users who have rated the movie; omit if unrated
+
+	Poster Poster `json:"poster,omitzero"` // URLs of the movie's poster and its thumbnails; omit if there is no poster
+
+	// TitleLocale is set when the title has been replaced by an alternate title in one
+	// of the client's preferred languages. It isn't stored in the movies table.
+	TitleLocale string `json:"title_locale,omitempty"` // Locale of the localized title; omit for the canonical title
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie() checks the fields of a movie. knownGenres holds the slugs of the
+// genres in the taxonomy, and every genre of the movie must be one of them.
+func ValidateMovie(v *validator.Validator, movie *Movie, knownGenres []string) {
 	// Use the Check() method to execute our validation checks. This will add the
 	// provided key and error message to the errors map if the check does not evaluate
 	// to true. For example, in the first line here we "check that the title is not
@@ -53,6 +69,22 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Note that we're using the Unique helper in the line below to check that all
 	// values in the movie.Genres slice are unique.
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	for _, genre := range movie.Genres {
+		if !validator.PermittedValue(genre, knownGenres...) {
+			v.AddError("genres", fmt.Sprintf("contains unknown genre %q", genre))
+			break
+		}
+	}
+
+	// The external IDs are optional, but must be well-formed if they're given.
+	if movie.ImdbID != "" {
+		v.Check(validator.Matches(movie.ImdbID, ImdbIDRX), "imdb_id", "must be an IMDb title ID, such as tt0111161")
+	}
+
+	v.Check(movie.TmdbID >= 0, "tmdb_id", "must be a positive integer")
+
+	validateReleases(v, movie.Releases)
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
@@ -61,31 +93,65 @@ type MovieModel struct {
 }
 
 // The Insert() method accepts a pointer to a movie struct, which should contain the
-// data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
+// data for the new record, and the ID of the user who is creating it. The insert and
+// the first revision of the movie are saved in a single transaction.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	// Create a context with a 3-second timeout.ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertMovie inserts a movie and its first revision as part of an existing
+// transaction.
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	// Define the SQL query for inserting a new record in the movies table and returning
 	// the system-generated data.
 	query := `
-	INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO movies (title, year, runtime, genres, imdb_id, tmdb_id, releases)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id, created_at, version;`
 
 	// Create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immediately next to our SQL query helps to
 	// make it nice and clear *what values are being used where* in the query.
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-
-	// Create a context with a 3-second timeout.ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
-	defer cancel()
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ImdbID, movie.TmdbID, movie.Releases}
+
+	// Use the QueryRow() method to execute the SQL query in the transaction, passing in
+	// the args slice as a variadic parameter and scanning the system-generated id,
+	// created_at and version values into the movie struct. If one of the movie's
+	// external IDs already belongs to another movie, uniqueMovieError() converts the
+	// error into ErrDuplicateImdbID or ErrDuplicateTmdbID.
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return uniqueMovieError(err)
+	}
 
-	// Use the QueryRow() method to execute the SQL query on our connection pool,
-	// passing in the args slice as a variadic parameter and scanning the system-
-	// generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return insertMovieRevision(ctx, tx, movie, RevisionActionInsert, userID, diffSnapshots(nil, SnapshotMovie(movie)))
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// GetFields() is like Get(), but only fetches the columns for the given sparse
+// fieldset. An empty fieldset fetches every column.
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that. To avoid making an unnecessary database call, we take a shortcut
@@ -94,14 +160,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	// Declare a Movie struct to hold the data returned by the query, and get the
+	// columns to select along with the fields of the struct to scan them into.
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, fields)
+
 	// Define the SQL query for retrieving the movie data.
-	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
+	query := fmt.Sprintf(`
+	SELECT %s
 	FROM movies
-	WHERE id = $1`
-
-	// Declare a Movie struct to hold the data returned by the query.
-	var movie Movie
+	WHERE id = $1 AND deleted_at IS NULL`, columns)
 
 	// Use the context.WithTimeout() function to create a context.Context which carries a
 	// 3-second timeout deadline. Note that we're using the empty context.Background()
@@ -114,19 +183,10 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Execute the query using the QueryRow() method, passing in the provided id value
 	// as a placeholder parameter, and scan the response data into the fields of the
-	// Movie struct. Importantly, notice that we need to convert the scan target for the
-	// genres column using the pq.Array() adapter function again.
+	// Movie struct.
 	// Use the QueryRowContext() method to execute the query, passing in the context
 	// with the deadline as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
 	// a sql.ErrNoRows error. We check for this and return our custom ErrRecordNotFound
@@ -145,14 +205,113 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// MovieFieldsSafelist holds the fields of the movie representation which clients can
+// select with a sparse fieldset.
+var MovieFieldsSafelist = []string{"id", "title", "year", "runtime", "genres", "version", "imdb_id", "tmdb_id", "releases", "average_rating", "rating_count", "poster"}
+
+// movieColumns() returns the columns to select for a sparse fieldset, along with the
+// fields of movie to scan them into (notice that the genres column needs the pq.Array()
+// adapter, and that the poster field is made up of two columns). The id, version,
+// rating and poster thumbnail columns are always selected, as they're needed for ETags,
+// and an empty fieldset selects every column. Only the column names below are ever
+// returned, so the result is safe to interpolate into a query.
+func movieColumns(movie *Movie, fields []string) (string, []any) {
+	all := []struct {
+		field  string
+		column string
+		dest   any
+		always bool
+	}{
+		{"id", "id", &movie.ID, true},
+		{"created_at", "created_at", &movie.CreatedAt, false},
+		{"title", "title", &movie.Title, false},
+		{"year", "year", &movie.Year, false},
+		{"runtime", "runtime", &movie.Runtime, false},
+		{"genres", "genres", pq.Array(&movie.Genres), false},
+		{"version", "version", &movie.Version, true},
+		{"imdb_id", "imdb_id", &movie.ImdbID, false},
+		{"tmdb_id", "tmdb_id", &movie.TmdbID, false},
+		{"releases", "releases", &movie.Releases, false},
+		{"average_rating", "average_rating", &movie.AverageRating, true},
+		{"rating_count", "rating_count", &movie.RatingCount, true},
+		{"poster", "poster", &movie.Poster.Key, false},
+		{"poster", "poster_thumbnails", pq.Array(&movie.Poster.Thumbnails), true},
+	}
+
+	var (
+		columns []string
+		dest    []any
+	)
+
+	for _, c := range all {
+		if len(fields) == 0 || c.always || slices.Contains(fields, c.field) {
+			columns = append(columns, c.column)
+			dest = append(dest, c.dest)
+		}
+	}
+
+	return strings.Join(columns, ", "), dest
+}
+
+// Update() saves the changes to a movie, recording a revision with a field-level diff
+// against the previous version. userID is the ID of the user making the change.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	return m.update(movie, userID, RevisionActionUpdate)
+}
+
+// Revert() works like Update(), but for a movie whose fields have been set back to an
+// earlier revision (see MovieSnapshot.ApplyTo()). The change is recorded in the history
+// as a revert rather than an update.
+func (m MovieModel) Revert(movie *Movie, userID int64) error {
+	return m.update(movie, userID, RevisionActionRevert)
+}
+
+func (m MovieModel) update(movie *Movie, userID int64, action string) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Read the current state of the movie so that we can work out what has changed,
+	// locking the row until the transaction ends. If there's no live movie with the
+	// expected version then somebody else got there first.
+	var before MovieSnapshot
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT title, year, runtime, genres, imdb_id, tmdb_id, releases
+	FROM movies
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	FOR UPDATE`, movie.ID, movie.Version).Scan(
+		&before.Title,
+		&before.Year,
+		&before.Runtime,
+		pq.Array(&before.Genres),
+		&before.ImdbID,
+		&before.TmdbID,
+		&before.Releases,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
-	// Add the `AND version = $6` clause.
+	// Add the `AND version = $9` clause.
 	query := `
 	UPDATE movies
-	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET title = $1, year = $2, runtime = $3, genres = $4, imdb_id = $5, tmdb_id = $6, releases = $7, version = version + 1
+	WHERE id = $8 AND version = $9 AND deleted_at IS NULL
 	RETURNING version`
 
 	// Create an args slice containing the values for the placeholder parameters.
@@ -161,104 +320,290 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.ImdbID,
+		movie.TmdbID,
+		movie.Releases,
 		movie.ID,
 		movie.Version,
 	}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
-	defer cancel()
-
 	// Use the QueryRow() method to execute the query, passing in the args slice as a
 	// variadic parameter and scanning the new version value into the movie struct.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return uniqueMovieError(err)
 		}
 	}
 
-	return nil
+	err = insertMovieRevision(ctx, tx, movie, action, userID, diffSnapshots(&before, SnapshotMovie(movie)))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete() moves a movie to the trash by setting its deleted_at timestamp, rather than
+// removing the row. Trashed movies are hidden from every other read query, and can be
+// brought back with Restore() until they are purged. If version is non-zero, the movie
+// is only deleted if it is still at that version. userID is the ID of the user deleting
+// the movie, and is recorded in the movie's history.
+func (m MovieModel) Delete(id int64, version int32, userID int64) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
+	// Construct the SQL query to trash the record. We bump the version number too, as
+	// the state of the movie has changed.
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, nil)
+
 	query := `
-	DELETE FROM movies
-	WHERE id = $1`
+	UPDATE movies
+	SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL AND (version = $2 OR $2 = 0)
+	RETURNING ` + columns
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
 	defer cancel()
 
-	// Execute the SQL query using the Exec() method, passing in the id variable as
-	// the value for the placeholder parameter. The Exec() method returns a sql.Result
-	// object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query.
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	// If no row is returned, we know that the movies table didn't contain a live record
+	// with the provided ID (and version) at the moment we tried to delete it. In that
+	// case we return an ErrRecordNotFound error.
+	err = tx.QueryRowContext(ctx, query, id, version).Scan(dest...)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = insertMovieRevision(ctx, tx, &movie, RevisionActionDelete, userID, map[string]FieldChange{})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // Create a new GetAll() method which returns a slice of movies. Although we're not
 // using them right now, we've set this up to accept the various filter parameters as
 // arguments.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// MovieSearch holds the conditions which the movies returned by GetAll() must match.
+// Zero values mean that the condition isn't applied.
+type MovieSearch struct {
+	// The title search accepts web search syntax (so "quoted phrases", "or" and
+	// -excluded words all work), and matches the last word as a prefix. If Fuzzy is
+	// true, titles which are similar to the search term also match, to allow for typos.
+	Title string
+	Fuzzy bool
+
+	YearMin    int
+	YearMax    int
+	RuntimeMin int
+	RuntimeMax int
+
+	// Movies must have all of the GenresAll, at least one of the GenresAny, and none
+	// of the GenresExclude.
+	GenresAll     []string
+	GenresAny     []string
+	GenresExclude []string
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Looking a movie up by one of its external IDs matches at most one movie.
+	ImdbID string
+	TmdbID int
+}
+
+func ValidateMovieSearch(v *validator.Validator, s MovieSearch) {
+	maxYear := time.Now().Year()
+
+	if s.ImdbID != "" {
+		v.Check(validator.Matches(s.ImdbID, ImdbIDRX), "imdb_id", "must be an IMDb title ID, such as tt0111161")
+	}
+
+	v.Check(s.TmdbID >= 0, "tmdb_id", "must be a positive integer")
+
+	if s.YearMin != 0 {
+		v.Check(s.YearMin >= 1888, "year_min", "must be greater than 1888")
+		v.Check(s.YearMin <= maxYear, "year_min", "must not be in the future")
+	}
+
+	if s.YearMax != 0 {
+		v.Check(s.YearMax >= 1888, "year_max", "must be greater than 1888")
+		v.Check(s.YearMax <= maxYear, "year_max", "must not be in the future")
+	}
+
+	if s.YearMin != 0 && s.YearMax != 0 {
+		v.Check(s.YearMin <= s.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(s.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(s.RuntimeMax >= 0, "runtime_max", "must not be negative")
+
+	if s.RuntimeMin != 0 && s.RuntimeMax != 0 {
+		v.Check(s.RuntimeMin <= s.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	for key, genres := range map[string][]string{"genres_all": s.GenresAll, "genres_any": s.GenresAny, "genres_exclude": s.GenresExclude} {
+		v.Check(len(genres) <= 20, key, "must not contain more than 20 genres")
+		v.Check(validator.Unique(genres), key, "must not contain duplicate values")
+	}
+
+	for _, genre := range s.GenresExclude {
+		if slices.Contains(s.GenresAll, genre) || slices.Contains(s.GenresAny, genre) {
+			v.AddError("genres_exclude", "must not contain genres which are also being searched for")
+			break
+		}
+	}
+
+	if !s.CreatedAfter.IsZero() && !s.CreatedBefore.IsZero() {
+		v.Check(s.CreatedAfter.Before(s.CreatedBefore), "created_after", "must be before created_before")
+	}
+}
+
+// where() builds the WHERE clause for the live movies which match the search. The
+// values are all passed as arguments, so only our own SQL and the generated
+// placeholders end up in the query. If there is a title search, it also returns the
+// SQL expression for ranking the matches.
+func (s MovieSearch) where() (*whereClause, string) {
+	where := &whereClause{}
+	where.add("deleted_at IS NULL")
+
+	var rank string
+
+	// A title search matches the movie's own title or any of its alternate titles, and
+	// the movie is ranked by whichever of them matches best.
+	if s.Title != "" {
+		title := where.arg(s.Title)
+		prefix := where.arg(prefixTSQuery(s.Title))
+
+		where.add(fmt.Sprintf(`(
+		%s
+		OR EXISTS (
+			SELECT 1 FROM movie_titles mt
+			WHERE mt.movie_id = movies.id AND %s
+		)
+	)`, titleMatch("title", title, prefix, s.Fuzzy), titleMatch("mt.title", title, prefix, s.Fuzzy)))
+
+		rank = fmt.Sprintf(`GREATEST(
+		%s,
+		(SELECT max(%s) FROM movie_titles mt WHERE mt.movie_id = movies.id)
+	)`, movieSearchRank("title", title, prefix), movieSearchRank("mt.title", title, prefix))
+	}
+
+	if s.YearMin != 0 {
+		where.add("year >= " + where.arg(s.YearMin))
+	}
+
+	if s.YearMax != 0 {
+		where.add("year <= " + where.arg(s.YearMax))
+	}
+
+	if s.RuntimeMin != 0 {
+		where.add("runtime >= " + where.arg(s.RuntimeMin))
+	}
+
+	if s.RuntimeMax != 0 {
+		where.add("runtime <= " + where.arg(s.RuntimeMax))
+	}
+
+	if len(s.GenresAll) > 0 {
+		where.add("genres @> " + where.arg(pq.Array(s.GenresAll)))
+	}
+
+	if len(s.GenresAny) > 0 {
+		where.add("genres && " + where.arg(pq.Array(s.GenresAny)))
+	}
+
+	if len(s.GenresExclude) > 0 {
+		where.add("NOT (genres && " + where.arg(pq.Array(s.GenresExclude)) + ")")
+	}
+
+	if !s.CreatedAfter.IsZero() {
+		where.add("created_at > " + where.arg(s.CreatedAfter))
+	}
+
+	if !s.CreatedBefore.IsZero() {
+		where.add("created_at < " + where.arg(s.CreatedBefore))
+	}
+
+	if s.ImdbID != "" {
+		where.add("imdb_id = " + where.arg(s.ImdbID))
+	}
+
+	if s.TmdbID != 0 {
+		where.add("tmdb_id = " + where.arg(s.TmdbID))
+	}
+
+	return where, rank
+}
+
+// GetAll() returns a page of the live movies which match the search. Sorting by
+// "relevance" puts the best title matches first. Only the columns for the given sparse
+// fieldset are fetched.
+func (m MovieModel) GetAll(search MovieSearch, fields []string, filters Filters) ([]*Movie, Metadata, error) {
+	where, rank := search.where()
+
+	// Relevance isn't a column, so rather than interpolating the sort column and
+	// direction we order by the search rank, best matches first. Without a title
+	// search every movie is equally relevant.
+	orderBy := fmt.Sprintf("%s %s", filters.sortColumn(), filters.sortDirection())
+
+	if filters.sortColumn() == "relevance" {
+		orderBy = "id ASC"
+
+		if rank != "" {
+			orderBy = rank + " DESC"
+		}
+	}
+
+	// Sorting by "rating" uses the average rating, with the number of ratings breaking
+	// ties so that a movie with many high ratings ranks above one with a single rating.
+	if filters.sortColumn() == "rating" {
+		orderBy = fmt.Sprintf("average_rating %[1]s, rating_count %[1]s", filters.sortDirection())
+	}
+
 	// Add an ORDER BY clause and interpolate the sort column and direction. Importantly
 	// notice that we also include a secondary sort on the movie ID to ensure a
 	// consistent ordering.
+	columns, _ := movieColumns(&Movie{}, fields)
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), %s
 	FROM movies
-	WHERE (
-		to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = ''
-	)
-	AND (
-		genres @> $2 OR $2 = '{}'
-	)
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4;`,
-		filters.sortColumn(), filters.sortDirection(),
+	WHERE %s
+	ORDER BY %s, id ASC
+	LIMIT %s OFFSET %s;`,
+		columns, where, orderBy, where.arg(filters.limit()), where.arg(filters.offset()),
 	)
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
 	defer cancel()
 
-	// As our SQL query now has quite a few placeholder parameters, let's collect the
-	// values for the placeholders in a slice. Notice here how we call the limit() and
-	// offset() methods on the Filters struct to get the appropriate values for the
-	// LIMIT and OFFSET clauses.
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
-	// containing the result.
-	// Pass the title and genres as the placeholder parameter values.
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	// containing the result. The arguments for the placeholders, including the LIMIT
+	// and OFFSET values, have been collected by the WHERE clause builder.
+	rows, err := m.DB.QueryContext(ctx, query, where.args...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
@@ -276,18 +621,11 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		// Initialize an empty Movie struct to hold the data for an individual movie.
 		var movie Movie
 
-		// Scan the values from the row into the Movie struct. Again, note that we're
-		// using the pq.Array() adapter on the genres field here.
-		err := rows.Scan(
-			&totalRecords, // Scan the count from the window function into totalRecords.
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		// Scan the values from the row into the Movie struct, after scanning the count
+		// from the window function into totalRecords.
+		_, dest := movieColumns(&movie, fields)
+
+		err := rows.Scan(append([]any{&totalRecords}, dest...)...)
 		if err != nil {
 			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 		}
@@ -310,3 +648,215 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Include the metadata struct when returning.
 	return movies, metadata, nil
 }
+
+// titleMatch() returns the SQL condition for whether the title in the given column
+// matches a search, given the placeholders for the search and prefix queries. Fuzzy
+// searches also match titles which are similar to the search.
+func titleMatch(column, title, prefix string, fuzzy bool) string {
+	condition := fmt.Sprintf(`(
+			to_tsvector('simple', %[1]s) @@ websearch_to_tsquery('simple', %[2]s)
+			OR (%[3]s <> '' AND to_tsvector('simple', %[1]s) @@ to_tsquery('simple', %[3]s))`, column, title, prefix)
+
+	if fuzzy {
+		condition += fmt.Sprintf("\n\t\t\tOR %s <%% %s", title, column)
+	}
+
+	return condition + "\n\t\t)"
+}
+
+// movieSearchRank() returns the SQL expression used to rank how well the title in the
+// given column matches a search, given the placeholders for the search and prefix
+// queries. It combines the full-text rank of both queries with the trigram word
+// similarity, so that fuzzy matches are ranked below exact ones.
+func movieSearchRank(column, title, prefix string) string {
+	return fmt.Sprintf(`(
+		ts_rank(to_tsvector('simple', %[1]s), websearch_to_tsquery('simple', %[2]s))
+		+ CASE WHEN %[3]s <> '' THEN ts_rank(to_tsvector('simple', %[1]s), to_tsquery('simple', %[3]s)) ELSE 0 END
+		+ word_similarity(%[2]s, %[1]s)
+	)`, column, title, prefix)
+}
+
+// prefixTSQuery converts a title search into a tsquery which matches all of its words,
+// treating the last one as a prefix, so that "black pan" matches "Black Panther". It
+// returns an empty string if the search uses web search syntax (quoted phrases, "or",
+// or -excluded words), as turning those into a prefix search would change what they
+// mean.
+func prefixTSQuery(search string) string {
+	if strings.Contains(search, `"`) {
+		return ""
+	}
+
+	var words []string
+
+	for _, field := range strings.Fields(search) {
+		if strings.HasPrefix(field, "-") || strings.EqualFold(field, "or") {
+			return ""
+		}
+
+		// Only keep letters and digits, so that the words can't contain any tsquery
+		// operators.
+		word := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, field)
+
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	words[len(words)-1] += ":*"
+
+	return strings.Join(words, " & ")
+}
+
+// GetTrashed() returns a page of the movies which are currently in the trash.
+func (m MovieModel) GetTrashed(filters Filters) ([]*Movie, Metadata, error) {
+	columns, _ := movieColumns(&Movie{}, nil)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), deleted_at, %s
+	FROM movies
+	WHERE deleted_at IS NOT NULL
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2;`,
+		columns, filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movieColumns(&movie, nil)
+
+		err := rows.Scan(append([]any{&totalRecords, &movie.DeletedAt}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Restore() takes a movie out of the trash and returns the restored record. If there
+// is no trashed movie with the given ID, it returns ErrRecordNotFound.
+func (m MovieModel) Restore(id int64, userID int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, nil)
+
+	query := `
+	UPDATE movies
+	SET deleted_at = NULL, version = version + 1
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	RETURNING ` + columns
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(dest...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertMovieRevision(ctx, tx, &movie, RevisionActionRestore, userID, map[string]FieldChange{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// PurgeTrashed() permanently deletes any movies which were moved to the trash more
+// than the given duration ago. It returns the number of movies that were removed, and
+// the keys of their posters, so that the caller can delete the files from the blob
+// store.
+func (m MovieModel) PurgeTrashed(olderThan time.Duration) (int64, []string, error) {
+	query := `
+	DELETE FROM movies
+	WHERE deleted_at IS NOT NULL AND deleted_at < $1
+	RETURNING poster`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer rows.Close()
+
+	var (
+		purged int64
+		keys   []string
+	)
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		purged++
+
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, keys, nil
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// BulkCopyThreshold is the number of movies at which InsertMany() switches from
+// individual INSERT statements to loading the movies with COPY.
+const BulkCopyThreshold = 100
+
+// InsertMany() inserts a batch of movies and their first revisions in a single
+// transaction, so either all of the movies are saved or none are. The system-generated
+// fields of each movie are filled in, as with Insert().
+func (m MovieModel) InsertMany(movies []*Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), BulkTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	if len(movies) < BulkCopyThreshold {
+		for _, movie := range movies {
+			err = insertMovie(ctx, tx, movie, userID)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err = copyMovies(ctx, tx, movies, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// copyMovies loads a large batch of movies with COPY. COPY can't return the generated
+// columns, so we copy the movies into a temporary staging table first. The staging
+// table takes its IDs from the movies sequence, which lets us match the inserted rows
+// back up with the movies in the batch.
+func copyMovies(ctx context.Context, tx *sql.Tx, movies []*Movie, userID int64) error {
+	query := `
+	CREATE TEMPORARY TABLE movies_import (
+		id bigint NOT NULL DEFAULT nextval(pg_get_serial_sequence('movies', 'id')),
+		ord integer NOT NULL,
+		title text NOT NULL,
+		year integer NOT NULL,
+		runtime integer NOT NULL,
+		genres text[] NOT NULL,
+		imdb_id text NOT NULL,
+		tmdb_id bigint NOT NULL,
+		releases jsonb NOT NULL
+	) ON COMMIT DROP`
+
+	_, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies_import", "ord", "title", "year", "runtime", "genres", "imdb_id", "tmdb_id", "releases"))
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for i, movie := range movies {
+		_, err = stmt.ExecContext(ctx, i, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ImdbID, movie.TmdbID, movie.Releases)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Calling Exec() with no arguments flushes the buffered rows to the database.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		return err
+	}
+
+	query = `
+	WITH inserted AS (
+		INSERT INTO movies (id, title, year, runtime, genres, imdb_id, tmdb_id, releases)
+		SELECT id, title, year, runtime, genres, imdb_id, tmdb_id, releases
+		FROM movies_import
+		ORDER BY ord
+		RETURNING id, created_at, version
+	)
+	SELECT movies_import.ord, inserted.id, inserted.created_at, inserted.version
+	FROM inserted
+	INNER JOIN movies_import ON movies_import.id = inserted.id`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return uniqueMovieError(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			ord       int
+			id        int64
+			createdAt time.Time
+			version   int32
+		)
+
+		err = rows.Scan(&ord, &id, &createdAt, &version)
+		if err != nil {
+			return err
+		}
+
+		movies[ord].ID = id
+		movies[ord].CreatedAt = createdAt
+		movies[ord].Version = version
+	}
+
+	if err = rows.Err(); err != nil {
+		return uniqueMovieError(err)
+	}
+
+	// Record the first revision and the created event of every movie with a single
+	// statement. The snapshot has the same shape as MovieSnapshot, and every field
+	// appears in the changes with a null "from" value, as diffSnapshots() does for new
+	// movies.
+	query = `
+	WITH revisions AS (
+		INSERT INTO movie_revisions (movie_id, version, action, user_id, snapshot, changes)
+		SELECT id, 1, $1, NULLIF($2, 0), snapshot, (
+			SELECT jsonb_object_agg(key, jsonb_build_object('from', NULL, 'to', value))
+			FROM jsonb_each(snapshot)
+		)
+		FROM (
+			SELECT id, jsonb_build_object(
+				'title', title,
+				'year', year,
+				'runtime', runtime || ' mins',
+				'genres', genres,
+				'imdb_id', imdb_id,
+				'tmdb_id', tmdb_id,
+				'releases', releases
+			) AS snapshot
+			FROM movies_import
+		) AS snapshots
+		RETURNING movie_id, version, snapshot, changes
+	), events AS (
+		INSERT INTO movie_events (movie_id, version, type, snapshot, changes)
+		SELECT movie_id, version, $3, snapshot, changes
+		FROM revisions
+		ORDER BY movie_id
+		RETURNING id, type
+	)` + fanOutMovieEvents
+
+	_, err = tx.ExecContext(ctx, query, RevisionActionInsert, userID, EventMovieCreated)
+	return err
+}
+
+// Export() calls fn with each live movie in turn, in ID order. The movies are streamed
+// from the database rather than loaded into memory up front, and the export runs until
+// ctx is cancelled, so callers should pass a context tied to the request.
+func (m MovieModel) Export(ctx context.Context, fn func(*Movie) error) error {
+	query := `
+	SELECT id, created_at, title, year, runtime, genres, imdb_id, tmdb_id, releases, version
+	FROM movies
+	WHERE deleted_at IS NULL
+	ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.ImdbID,
+			&movie.TmdbID,
+			&movie.Releases,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
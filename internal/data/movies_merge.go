@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// MaxDuplicateCandidates is the most likely duplicates that FindDuplicates() returns.
+const MaxDuplicateCandidates = 5
+
+// FindDuplicates() returns the live movies which are likely to be duplicates of a new
+// movie with the given title and year, best matches first. A movie is a likely
+// duplicate if it was released within a year of the new one, and its title is either
+// the same once case, spacing and punctuation are ignored ("Toy Story" and "toy-story"),
+// or very similar by trigram similarity ("Toy Story" and "Toy Stroy").
+func (m MovieModel) FindDuplicates(title string, year int32) ([]*Movie, error) {
+	columns, _ := movieColumns(&Movie{}, nil)
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM movies
+	WHERE deleted_at IS NULL
+	AND year BETWEEN $2 - 1 AND $2 + 1
+	AND (
+		regexp_replace(lower(title), '[^[:alnum:]]+', '', 'g') = regexp_replace(lower($1), '[^[:alnum:]]+', '', 'g')
+		OR similarity(title, $1) >= 0.6
+	)
+	ORDER BY similarity(title, $1) DESC, abs(year - $2), id
+	LIMIT $3`, columns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, year, MaxDuplicateCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movieColumns(&movie, nil)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// MergeResult holds the number of rows of each kind which were moved from the
+// duplicate movie to the movie it was merged into.
+type MergeResult struct {
+	Credits        int64 `json:"credits"`
+	Ratings        int64 `json:"ratings"`
+	Reviews        int64 `json:"reviews"`
+	Titles         int64 `json:"titles"`
+	WatchlistItems int64 `json:"watchlist_items"`
+}
+
+// Merge() merges the duplicate movie into the movie with the given ID. The credits,
+// ratings, reviews, alternate titles and watchlist items of the duplicate are moved
+// across, except where the movie already has an equivalent row (such as a rating by
+// the same user), in which case the movie's own row wins. The duplicate is then moved
+// to the trash, handing its IMDb and TMDb IDs to the movie if the movie doesn't
+// have its own. Both movies get a new version, and the merge is recorded in both of
+// their histories. It returns ErrRecordNotFound if either movie isn't live.
+func (m MovieModel) Merge(id, duplicateID int64, userID int64) (*MergeResult, error) {
+	if id < 1 || duplicateID < 1 || id == duplicateID {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	// Lock both movies, in ID order so that two merges of the same pair can't deadlock.
+	var locked int
+
+	err = tx.QueryRowContext(ctx, `
+	WITH locked AS (
+		SELECT id
+		FROM movies
+		WHERE id IN ($1, $2) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	)
+	SELECT count(*) FROM locked`, id, duplicateID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked != 2 {
+		return nil, ErrRecordNotFound
+	}
+
+	result := &MergeResult{}
+
+	// Each statement moves the duplicate's rows which don't clash with one of the
+	// movie's own rows. Any rows which are left behind stay with the duplicate in the
+	// trash.
+	moves := []struct {
+		count *int64
+		query string
+	}{
+		{&result.Credits, `
+		UPDATE movie_credits c
+		SET movie_id = $1
+		WHERE c.movie_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM movie_credits m
+			WHERE m.movie_id = $1 AND m.person_id = c.person_id AND m.role = c.role AND m.character = c.character
+		)`},
+		// The trigger on the ratings table moves each rating's contribution to the
+		// rating aggregates across too.
+		{&result.Ratings, `
+		UPDATE ratings r
+		SET movie_id = $1
+		WHERE r.movie_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM ratings m
+			WHERE m.movie_id = $1 AND m.user_id = r.user_id
+		)`},
+		{&result.Reviews, `
+		UPDATE reviews r
+		SET movie_id = $1
+		WHERE r.movie_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM reviews m
+			WHERE m.movie_id = $1 AND m.user_id = r.user_id
+		)`},
+		// The duplicate's original title stays original only if the movie doesn't
+		// already have one.
+		{&result.Titles, `
+		UPDATE movie_titles t
+		SET movie_id = $1, is_original = t.is_original AND NOT EXISTS (
+			SELECT 1 FROM movie_titles m
+			WHERE m.movie_id = $1 AND m.is_original
+		)
+		WHERE t.movie_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM movie_titles m
+			WHERE m.movie_id = $1 AND m.locale = t.locale AND m.title = t.title
+		)`},
+	}
+
+	for _, move := range moves {
+		res, err := tx.ExecContext(ctx, move.query, id, duplicateID)
+		if err != nil {
+			return nil, err
+		}
+
+		*move.count, err = res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.WatchlistItems, err = mergeWatchlistItems(ctx, tx, id, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read both movies' external IDs. The duplicate's are cleared when it's trashed,
+	// as they're unique even among trashed movies, and the movie takes any that it
+	// doesn't already have.
+	var (
+		imdbID, duplicateImdbID string
+		tmdbID, duplicateTmdbID int64
+	)
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT m.imdb_id, m.tmdb_id, d.imdb_id, d.tmdb_id
+	FROM movies m, movies d
+	WHERE m.id = $1 AND d.id = $2`, id, duplicateID).Scan(&imdbID, &tmdbID, &duplicateImdbID, &duplicateTmdbID)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicateChanges := map[string]FieldChange{
+		"merged_into": {From: nil, To: id},
+	}
+
+	changes := map[string]FieldChange{
+		"merged_from": {From: nil, To: duplicateID},
+	}
+
+	if duplicateImdbID != "" {
+		duplicateChanges["imdb_id"] = FieldChange{From: duplicateImdbID, To: ""}
+
+		if imdbID == "" {
+			changes["imdb_id"] = FieldChange{From: imdbID, To: duplicateImdbID}
+			imdbID = duplicateImdbID
+		}
+	}
+
+	if duplicateTmdbID != 0 {
+		duplicateChanges["tmdb_id"] = FieldChange{From: duplicateTmdbID, To: int64(0)}
+
+		if tmdbID == 0 {
+			changes["tmdb_id"] = FieldChange{From: tmdbID, To: duplicateTmdbID}
+			tmdbID = duplicateTmdbID
+		}
+	}
+
+	// Move the duplicate to the trash, recording where it went in its history.
+	var duplicate Movie
+
+	columns, dest := movieColumns(&duplicate, nil)
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE movies
+	SET deleted_at = NOW(), imdb_id = '', tmdb_id = 0, version = version + 1
+	WHERE id = $1
+	RETURNING `+columns, duplicateID).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertMovieRevision(ctx, tx, &duplicate, RevisionActionMerge, userID, duplicateChanges)
+	if err != nil {
+		return nil, err
+	}
+
+	// The duplicate's external IDs have been cleared, so the movie can take them
+	// without tripping the unique indexes. touchMovie() gives it the new version.
+	_, err = tx.ExecContext(ctx, `
+	UPDATE movies
+	SET imdb_id = $2, tmdb_id = $3
+	WHERE id = $1`, id, imdbID, tmdbID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = touchMovie(ctx, tx, id, userID, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mergeWatchlistItems moves the duplicate movie's watchlist items to the movie it's
+// being merged into, as part of an existing transaction, and returns how many were
+// moved. Watchlists which already have the movie just lose the duplicate, and the
+// items after it move up. Every watchlist which changes gets a new version.
+func mergeWatchlistItems(ctx context.Context, tx *sql.Tx, id, duplicateID int64) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT d.watchlist_id
+	FROM watchlist_items d
+	INNER JOIN watchlist_items m ON m.watchlist_id = d.watchlist_id AND m.movie_id = $1
+	WHERE d.movie_id = $2
+	ORDER BY d.watchlist_id`, id, duplicateID)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	// Read all of the watchlist IDs before changing anything, as we can't run another
+	// statement in the transaction until the rows have been closed.
+	var clashes []int64
+
+	for rows.Next() {
+		var watchlistID int64
+
+		err := rows.Scan(&watchlistID)
+		if err != nil {
+			return 0, err
+		}
+
+		clashes = append(clashes, watchlistID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rows.Close()
+
+	for _, watchlistID := range clashes {
+		err = removeWatchlistItem(ctx, tx, watchlistID, duplicateID, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		err = touchWatchlist(ctx, tx, watchlistID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Each watchlist has the duplicate at most once, so the number of watchlists
+	// updated is the number of items moved.
+	res, err := tx.ExecContext(ctx, `
+	WITH moved AS (
+		UPDATE watchlist_items
+		SET movie_id = $1
+		WHERE movie_id = $2
+		RETURNING watchlist_id
+	)
+	UPDATE watchlists
+	SET version = version + 1
+	WHERE id IN (SELECT watchlist_id FROM moved)`, id, duplicateID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
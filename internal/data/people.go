@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// A Person is anyone who can be credited on a movie, whether in front of or behind
+// the camera.
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+// Define a PersonModel struct which wraps the connection pool.
+type PersonModel struct {
+	DB *sql.DB
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+	INSERT INTO people (name, biography)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name, person.Biography).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, biography, version
+	FROM people
+	WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll() returns a page of people. The name filter matches anyone whose name contains
+// the given text (ignoring case).
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	// As in UserModel.GetAll(), we use strpos() rather than LIKE so that any % or _
+	// characters in the filter are matched literally.
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, biography, version
+	FROM people
+	WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// Update() saves changes to a person, using optimistic locking on the version number.
+func (m PersonModel) Update(person *Person) error {
+	query := `
+	UPDATE people
+	SET name = $1, biography = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	args := []any{person.Name, person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() removes a person. Their credits are removed along with them by the ON
+// DELETE CASCADE foreign key.
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM people
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MediaURLPrefix is the path which blobs in the blob store are served under.
+const MediaURLPrefix = "/v1/media/"
+
+// A Poster is the poster image for a movie. Key is the blob store key of the uploaded
+// image, and Thumbnails holds the names of the thumbnail sizes which have been
+// generated for it so far (they're generated in the background after an upload).
+type Poster struct {
+	Key        string
+	Thumbnails []string
+}
+
+// IsZero() reports whether the movie has no poster, so that the poster is left out of
+// the movie JSON by the omitzero option.
+func (p Poster) IsZero() bool {
+	return p.Key == ""
+}
+
+// MarshalJSON() encodes the poster as the URLs of the image and its thumbnails.
+func (p Poster) MarshalJSON() ([]byte, error) {
+	thumbnails := make(map[string]string, len(p.Thumbnails))
+	for _, size := range p.Thumbnails {
+		thumbnails[size] = MediaURLPrefix + PosterThumbnailKey(p.Key, size)
+	}
+
+	return json.Marshal(struct {
+		URL        string            `json:"url"`
+		Thumbnails map[string]string `json:"thumbnails"`
+	}{
+		URL:        MediaURLPrefix + p.Key,
+		Thumbnails: thumbnails,
+	})
+}
+
+// PosterThumbnailKey() returns the blob store key for a thumbnail of the poster with
+// the given key. Thumbnails are always JPEGs, so "posters/1/a1b2.png" has a "small"
+// thumbnail at "posters/1/a1b2_small.jpg".
+func PosterThumbnailKey(key, size string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + size + ".jpg"
+}
+
+// SetPoster() replaces the poster of a live movie with the image stored under the
+// given key, clearing its thumbnails, and records the change in the movie's history.
+// It returns the updated movie along with the key of the poster it replaced (which is
+// empty if the movie didn't have one).
+func (m MovieModel) SetPoster(id int64, key string, userID int64) (*Movie, string, error) {
+	if id < 1 {
+		return nil, "", ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	var oldKey string
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT poster
+	FROM movies
+	WHERE id = $1 AND deleted_at IS NULL
+	FOR UPDATE`, id).Scan(&oldKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, nil)
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE movies
+	SET poster = $1, poster_thumbnails = '{}', version = version + 1
+	WHERE id = $2
+	RETURNING `+columns, key, id).Scan(dest...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	changes := map[string]FieldChange{
+		"poster": {From: oldKey, To: key},
+	}
+
+	err = insertMovieRevision(ctx, tx, &movie, RevisionActionUpdate, userID, changes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &movie, oldKey, nil
+}
+
+// SetPosterThumbnails() records which thumbnail sizes have been generated for a movie's
+// poster. Nothing is changed if the poster has been replaced in the meantime.
+func (m MovieModel) SetPosterThumbnails(id int64, key string, sizes []string) error {
+	query := `
+	UPDATE movies
+	SET poster_thumbnails = $1
+	WHERE id = $2 AND poster = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(sizes), id, key)
+	return err
+}
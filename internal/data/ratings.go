@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define a custom ErrDuplicateReview error.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// A Rating is a user's score for a movie, from 1 to 10. Each user has at most one
+// rating per movie.
+type Rating struct {
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Rating != 0, "rating", "must be provided")
+	v.Check(rating.Rating >= 1 && rating.Rating <= 10, "rating", "must be between 1 and 10")
+}
+
+// Define a RatingModel struct which wraps the connection pool.
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Set() saves a user's rating for a movie, replacing any rating they gave it before.
+// The movie's rating aggregates are updated by the trigger on the ratings table.
+func (m RatingModel) Set(rating *Rating) error {
+	query := `
+	INSERT INTO ratings (user_id, movie_id, rating)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET rating = EXCLUDED.rating, updated_at = NOW()
+	RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, rating.UserID, rating.MovieID, rating.Rating).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+}
+
+func (m RatingModel) Get(movieID, userID int64) (*Rating, error) {
+	query := `
+	SELECT movie_id, user_id, rating, created_at, updated_at
+	FROM ratings
+	WHERE movie_id = $1 AND user_id = $2`
+
+	var rating Rating
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&rating.MovieID,
+		&rating.UserID,
+		&rating.Rating,
+		&rating.CreatedAt,
+		&rating.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rating, nil
+}
+
+// Delete() removes a user's rating for a movie.
+func (m RatingModel) Delete(movieID, userID int64) error {
+	query := `
+	DELETE FROM ratings
+	WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// A Review is a user's written review of a movie. Each user can review a movie once.
+// Author and Rating are filled in from the user's name and their rating of the movie
+// (if they have rated it) when reviews are read back.
+type Review struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Author    string    `json:"author,omitempty"`
+	Rating    int32     `json:"rating,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Body != "", "body", "must be provided")
+	v.Check(len(review.Body) <= 20_000, "body", "must not be more than 20000 bytes long")
+}
+
+// Define a ReviewModel struct which wraps the connection pool.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+	INSERT INTO reviews (user_id, movie_id, body)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, review.UserID, review.MovieID, review.Body).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_movie_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get() returns a review of the given movie.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT r.id, r.movie_id, r.user_id, u.name, coalesce(ra.rating, 0), r.body, r.created_at, r.updated_at, r.version
+	FROM reviews r
+	INNER JOIN users u ON u.id = r.user_id
+	LEFT JOIN ratings ra ON ra.movie_id = r.movie_id AND ra.user_id = r.user_id
+	WHERE r.id = $1 AND r.movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.Author,
+		&review.Rating,
+		&review.Body,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie() returns a page of the reviews of a movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), r.id, r.movie_id, r.user_id, u.name, coalesce(ra.rating, 0), r.body, r.created_at, r.updated_at, r.version
+	FROM reviews r
+	INNER JOIN users u ON u.id = r.user_id
+	LEFT JOIN ratings ra ON ra.movie_id = r.movie_id AND ra.user_id = r.user_id
+	WHERE r.movie_id = $1
+	ORDER BY r.%s %s, r.id ASC
+	LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.Author,
+			&review.Rating,
+			&review.Body,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// Update() saves changes to a review, using optimistic locking on the version number.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+	UPDATE reviews
+	SET body = $1, updated_at = NOW(), version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, review.Body, review.ID, review.Version).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM reviews
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
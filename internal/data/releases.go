@@ -0,0 +1,108 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define custom errors for external IDs which already belong to another movie.
+var (
+	ErrDuplicateImdbID = errors.New("duplicate imdb id")
+	ErrDuplicateTmdbID = errors.New("duplicate tmdb id")
+)
+
+var (
+	// ImdbIDRX matches IMDb title IDs, such as "tt0111161".
+	ImdbIDRX = regexp.MustCompile("^tt[0-9]{7,10}$")
+
+	// CountryRX matches ISO 3166-1 alpha-2 country codes, such as "GB".
+	CountryRX = regexp.MustCompile("^[A-Z]{2}$")
+)
+
+// A Release is the release of a movie in a single country, along with the age
+// certification it was given there (such as "PG-13" or "12A"). Date is in the
+// YYYY-MM-DD format.
+type Release struct {
+	Country       string `json:"country"`
+	Date          string `json:"date"`
+	Certification string `json:"certification,omitempty"`
+}
+
+// Releases holds a movie's releases, ordered however the client sent them. They're
+// stored as a JSON array in the releases column of the movies table.
+type Releases []Release
+
+// MarshalJSON() encodes the releases as a JSON array. A nil slice is encoded as an
+// empty array rather than null, so that JSON Patch operations can append to it.
+func (r Releases) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal([]Release(r))
+}
+
+// Value() implements the driver.Valuer interface, so that the releases can be passed
+// straight to a query as the value of the releases column.
+func (r Releases) Value() (driver.Value, error) {
+	js, err := r.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return string(js), nil
+}
+
+// Scan() implements the sql.Scanner interface for the releases column.
+func (r *Releases) Scan(src any) error {
+	return jsonColumn{(*[]Release)(r)}.Scan(src)
+}
+
+// validateReleases checks the releases of a movie, recording the first problem under
+// the "releases" key.
+func validateReleases(v *validator.Validator, releases Releases) {
+	v.Check(len(releases) <= 250, "releases", "must not contain more than 250 releases")
+
+	countries := make(map[string]bool, len(releases))
+
+	for i, release := range releases {
+		date, err := time.Parse(time.DateOnly, release.Date)
+
+		switch {
+		case !validator.Matches(release.Country, CountryRX):
+			v.AddError("releases", fmt.Sprintf("release %d must have a two-letter country code, such as US or GB", i+1))
+		case countries[release.Country]:
+			v.AddError("releases", fmt.Sprintf("release %d is for the same country as an earlier release", i+1))
+		case err != nil:
+			v.AddError("releases", fmt.Sprintf("release %d must have a date in the format YYYY-MM-DD", i+1))
+		case date.Year() < 1888:
+			v.AddError("releases", fmt.Sprintf("release %d must have a date after 1888", i+1))
+		case len(release.Certification) > 20:
+			v.AddError("releases", fmt.Sprintf("release %d must have a certification of no more than 20 bytes", i+1))
+		}
+
+		countries[release.Country] = true
+	}
+}
+
+// uniqueMovieError converts the errors for violating the unique indexes on the
+// movies' external IDs into ErrDuplicateImdbID and ErrDuplicateTmdbID. Other errors
+// are returned unchanged.
+func uniqueMovieError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err.Error() == `pq: duplicate key value violates unique constraint "movies_imdb_id_idx"`:
+		return ErrDuplicateImdbID
+	case err.Error() == `pq: duplicate key value violates unique constraint "movies_tmdb_id_idx"`:
+		return ErrDuplicateTmdbID
+	default:
+		return err
+	}
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Define constants for the kinds of change that a movie revision can record.
+const (
+	RevisionActionInsert  = "insert"
+	RevisionActionUpdate  = "update"
+	RevisionActionDelete  = "delete"
+	RevisionActionRestore = "restore"
+	RevisionActionRevert  = "revert"
+	RevisionActionMerge   = "merge"
+)
+
+// MovieSnapshot holds the user-editable fields of a movie at a given version. It is
+// stored as JSON in the movie_revisions table.
+type MovieSnapshot struct {
+	Title    string   `json:"title"`
+	Year     int32    `json:"year"`
+	Runtime  Runtime  `json:"runtime"`
+	Genres   []string `json:"genres"`
+	ImdbID   string   `json:"imdb_id"`
+	TmdbID   int64    `json:"tmdb_id"`
+	Releases Releases `json:"releases"`
+}
+
+// FieldChange records the old and new value of a single field in a revision. From is
+// nil for newly inserted movies.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// A MovieRevision is one entry in the history of a movie. UserID is nil if the change
+// wasn't made by a user, or if the user has since been deleted.
+type MovieRevision struct {
+	ID        int64                  `json:"id"`
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	Action    string                 `json:"action"`
+	UserID    *int64                 `json:"user_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Snapshot  MovieSnapshot          `json:"snapshot"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// SnapshotMovie() returns a snapshot of the editable fields of a movie.
+func SnapshotMovie(movie *Movie) MovieSnapshot {
+	return MovieSnapshot{
+		Title:    movie.Title,
+		Year:     movie.Year,
+		Runtime:  movie.Runtime,
+		Genres:   movie.Genres,
+		ImdbID:   movie.ImdbID,
+		TmdbID:   movie.TmdbID,
+		Releases: movie.Releases,
+	}
+}
+
+// ApplyTo() copies the fields in the snapshot onto the given movie.
+func (s MovieSnapshot) ApplyTo(movie *Movie) {
+	movie.Title = s.Title
+	movie.Year = s.Year
+	movie.Runtime = s.Runtime
+	movie.Genres = s.Genres
+	movie.ImdbID = s.ImdbID
+	movie.TmdbID = s.TmdbID
+	movie.Releases = s.Releases
+}
+
+// diffSnapshots returns the fields which differ between two snapshots. If before is
+// nil (i.e. the movie has just been inserted), every field is included.
+func diffSnapshots(before *MovieSnapshot, after MovieSnapshot) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if before == nil {
+		changes["title"] = FieldChange{To: after.Title}
+		changes["year"] = FieldChange{To: after.Year}
+		changes["runtime"] = FieldChange{To: after.Runtime}
+		changes["genres"] = FieldChange{To: after.Genres}
+		changes["imdb_id"] = FieldChange{To: after.ImdbID}
+		changes["tmdb_id"] = FieldChange{To: after.TmdbID}
+		changes["releases"] = FieldChange{To: after.Releases}
+		return changes
+	}
+
+	if before.Title != after.Title {
+		changes["title"] = FieldChange{From: before.Title, To: after.Title}
+	}
+
+	if before.Year != after.Year {
+		changes["year"] = FieldChange{From: before.Year, To: after.Year}
+	}
+
+	if before.Runtime != after.Runtime {
+		changes["runtime"] = FieldChange{From: before.Runtime, To: after.Runtime}
+	}
+
+	if !slices.Equal(before.Genres, after.Genres) {
+		changes["genres"] = FieldChange{From: before.Genres, To: after.Genres}
+	}
+
+	if before.ImdbID != after.ImdbID {
+		changes["imdb_id"] = FieldChange{From: before.ImdbID, To: after.ImdbID}
+	}
+
+	if before.TmdbID != after.TmdbID {
+		changes["tmdb_id"] = FieldChange{From: before.TmdbID, To: after.TmdbID}
+	}
+
+	if !slices.Equal(before.Releases, after.Releases) {
+		changes["releases"] = FieldChange{From: before.Releases, To: after.Releases}
+	}
+
+	return changes
+}
+
+// insertMovieRevision records a revision for the given movie as part of an existing
+// transaction, so that the revision is only saved if the change to the movie is. A
+// userID of 0 is stored as NULL. The matching event is recorded in the outbox too.
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movie *Movie, action string, userID int64, changes map[string]FieldChange) error {
+	snapshot, err := json.Marshal(SnapshotMovie(movie))
+	if err != nil {
+		return err
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, action, user_id, snapshot, changes)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6)`
+
+	args := []any{movie.ID, movie.Version, action, userID, snapshot, changesJSON}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieEvent(ctx, tx, movie, action, snapshot, changesJSON)
+}
+
+// Define a RevisionModel struct which wraps the connection pool.
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie() returns a page of the revision history for a movie.
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, version, action, user_id, created_at, snapshot, changes
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Action,
+			&revision.UserID,
+			&revision.CreatedAt,
+			jsonColumn{&revision.Snapshot},
+			jsonColumn{&revision.Changes},
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Get() returns the revision of a movie at a specific version.
+func (m RevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT id, movie_id, version, action, user_id, created_at, snapshot, changes
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2
+		ORDER BY id DESC
+		LIMIT 1`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.UserID,
+		&revision.CreatedAt,
+		jsonColumn{&revision.Snapshot},
+		jsonColumn{&revision.Changes},
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// jsonColumn is a sql.Scanner which decodes a json or jsonb column into the value that
+// it wraps.
+type jsonColumn struct {
+	dst any
+}
+
+func (j jsonColumn) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, j.dst)
+	case string:
+		return json.Unmarshal([]byte(v), j.dst)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON column", src)
+	}
+}
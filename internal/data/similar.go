@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// titleStopwords are left out when comparing the words of two titles, as sharing them
+// says nothing about whether the movies are similar. The same list is used in Go and
+// in SQL.
+var titleStopwords = []string{"a", "an", "and", "in", "of", "on", "the", "to"}
+
+// A SimilarMovie is a movie recommended on the strength of its similarity to another
+// one. Score ranges from 0 (nothing in common) to 1.
+type SimilarMovie struct {
+	Score float64 `json:"score"`
+	Movie *Movie  `json:"movie"`
+}
+
+// titleTokens returns the distinct words of a title, in lowercase and without
+// punctuation or stopwords. It splits titles in the same way as the
+// regexp_split_to_table() call in GetSimilar().
+func titleTokens(title string) []string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := []string{}
+
+	for _, word := range words {
+		if !slices.Contains(titleStopwords, word) && !slices.Contains(tokens, word) {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+// MaxSimilarMovies is the most similar movies that RankSimilar() returns for a movie.
+const MaxSimilarMovies = 100
+
+// A SimilarityScore is the score of one movie in a ranking of the movies which are
+// similar to another one.
+type SimilarityScore struct {
+	MovieID int64
+	Score   float64
+}
+
+// RankSimilar() returns the IDs and scores of up to MaxSimilarMovies live movies which
+// are most similar to the given one, best matches first. Only movies which share at
+// least one genre are considered. Each is scored on a weighted combination of:
+//
+//   - genre overlap (the Jaccard index of the two sets of genres), weighted 0.5;
+//   - year proximity, 1 / (1 + years apart / 5), which falls off hyperbolically (to
+//     a half at 5 years apart, and a third at 10), weighted 0.2;
+//   - runtime similarity (the difference as a fraction of the longer runtime), weighted
+//     0.15;
+//   - title word overlap (the Jaccard index of the two sets of title words), weighted
+//     0.15.
+func (m MovieModel) RankSimilar(movie *Movie) ([]SimilarityScore, error) {
+	query := `
+	SELECT id, round(s.score::numeric, 3)::float8
+	FROM movies,
+	LATERAL (
+		SELECT
+			0.5 * (
+				SELECT count(*) FROM (SELECT unnest(genres) INTERSECT SELECT unnest($2::text[])) AS i
+			)::float8 / (
+				SELECT count(*) FROM (SELECT unnest(genres) UNION SELECT unnest($2::text[])) AS u
+			)
+			+ 0.2 / (1 + abs(year - $3) / 5.0)
+			+ 0.15 * (1 - abs(runtime - $4)::float8 / greatest(runtime, $4, 1))
+			+ 0.15 * coalesce((
+				SELECT count(*) FILTER (WHERE t = ANY($5::text[]))::float8 / nullif(count(*) + cardinality($5::text[]) - count(*) FILTER (WHERE t = ANY($5::text[])), 0)
+				FROM (
+					SELECT DISTINCT t
+					FROM regexp_split_to_table(lower(title), '[^[:alnum:]]+') AS t
+					WHERE t <> '' AND t <> ALL($6::text[])
+				) AS tokens
+			), 0)
+		AS score
+	) AS s
+	WHERE deleted_at IS NULL AND id <> $1 AND genres && $2::text[]
+	ORDER BY s.score DESC, id ASC
+	LIMIT $7`
+
+	args := []any{
+		movie.ID,
+		pq.Array(movie.Genres),
+		movie.Year,
+		movie.Runtime,
+		pq.Array(titleTokens(movie.Title)),
+		pq.Array(titleStopwords),
+		MaxSimilarMovies,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ranking := []SimilarityScore{}
+
+	for rows.Next() {
+		var s SimilarityScore
+
+		err := rows.Scan(&s.MovieID, &s.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		ranking = append(ranking, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ranking, nil
+}
+
+// GetSimilar() returns a page of a ranking from RankSimilar(), with the current state
+// of each movie on it. The ranking may be cached, so any movies on the page which have
+// since been moved to the trash are left out.
+func (m MovieModel) GetSimilar(ranking []SimilarityScore, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	similar := []*SimilarMovie{}
+
+	start := min(filters.offset(), len(ranking))
+	end := min(start+filters.limit(), len(ranking))
+
+	page := ranking[start:end]
+	if len(page) == 0 {
+		return similar, calculateMetadata(len(ranking), filters.Page, filters.PageSize), nil
+	}
+
+	ids := make([]int64, len(page))
+	for i, s := range page {
+		ids[i] = s.MovieID
+	}
+
+	columns, _ := movieColumns(&Movie{}, nil)
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM movies
+	WHERE id = ANY($1) AND deleted_at IS NULL`, columns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	movies := make(map[int64]*Movie, len(page))
+
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movieColumns(&movie, nil)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies[movie.ID] = &movie
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for _, s := range page {
+		if movie, ok := movies[s.MovieID]; ok {
+			similar = append(similar, &SimilarMovie{Score: s.Score, Movie: movie})
+		}
+	}
+
+	metadata := calculateMetadata(len(ranking), filters.Page, filters.PageSize)
+
+	return similar, metadata, nil
+}
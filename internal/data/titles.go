@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// Define custom errors for conflicting movie titles.
+var (
+	ErrDuplicateTitle         = errors.New("duplicate title")
+	ErrDuplicateOriginalTitle = errors.New("duplicate original title")
+)
+
+// LocaleRX matches the language tags which we accept as title locales, such as "fr",
+// "pt-BR" or "zh-Hant". It's a simplified form of the BCP 47 syntax.
+var LocaleRX = regexp.MustCompile("^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$")
+
+// A MovieTitle is an alternate title for a movie, such as a translation or the title it
+// was released under in another country. IsOriginal marks the title the movie was
+// first released under, in its original language.
+type MovieTitle struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"-"`
+	MovieID    int64     `json:"movie_id"`
+	Locale     string    `json:"locale"`
+	Title      string    `json:"title"`
+	IsOriginal bool      `json:"is_original"`
+}
+
+func ValidateMovieTitle(v *validator.Validator, title *MovieTitle) {
+	v.Check(title.Locale != "", "locale", "must be provided")
+	v.Check(len(title.Locale) <= 35, "locale", "must not be more than 35 bytes long")
+	v.Check(validator.Matches(title.Locale, LocaleRX), "locale", "must be a valid language tag, such as en or pt-BR")
+
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+// baseLanguage returns the language part of a locale, in lowercase, so "pt-BR" gives
+// "pt".
+func baseLanguage(locale string) string {
+	base, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	return base
+}
+
+// Define a MovieTitleModel struct which wraps the connection pool.
+type MovieTitleModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds an alternate title to a live movie. Titles are part of the movie, so
+// the movie's version number is bumped and the change is recorded in its history.
+func (m MovieTitleModel) Insert(title *MovieTitle, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO movie_titles (movie_id, locale, title, is_original)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{title.MovieID, title.Locale, title.Title, title.IsOriginal}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&title.ID, &title.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_titles_movie_id_locale_title_key"`:
+			return ErrDuplicateTitle
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_titles_original_idx"`:
+			return ErrDuplicateOriginalTitle
+		default:
+			return err
+		}
+	}
+
+	err = touchMovie(ctx, tx, title.MovieID, userID, map[string]FieldChange{
+		"titles": {From: nil, To: title},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForMovie() returns the alternate titles of a movie, ordered by locale with the
+// original title first.
+func (m MovieTitleModel) GetAllForMovie(movieID int64) ([]*MovieTitle, error) {
+	query := `
+	SELECT id, created_at, movie_id, locale, title, is_original
+	FROM movie_titles
+	WHERE movie_id = $1
+	ORDER BY is_original DESC, lower(locale), id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	titles := []*MovieTitle{}
+
+	for rows.Next() {
+		var title MovieTitle
+
+		err := rows.Scan(
+			&title.ID,
+			&title.CreatedAt,
+			&title.MovieID,
+			&title.Locale,
+			&title.Title,
+			&title.IsOriginal,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, &title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// Delete() removes an alternate title from a live movie, bumping the movie's version
+// number and recording the change in its history.
+func (m MovieTitleModel) Delete(movieID, id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM movie_titles
+	WHERE id = $1 AND movie_id = $2
+	RETURNING id, created_at, movie_id, locale, title, is_original`
+
+	var title MovieTitle
+
+	err = tx.QueryRowContext(ctx, query, id, movieID).Scan(
+		&title.ID,
+		&title.CreatedAt,
+		&title.MovieID,
+		&title.Locale,
+		&title.Title,
+		&title.IsOriginal,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = touchMovie(ctx, tx, movieID, userID, map[string]FieldChange{
+		"titles": {From: &title, To: nil},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Localize() replaces the title of each movie with its alternate title in the first of
+// the given locales that it has one for, and sets the movie's TitleLocale. A locale
+// matches titles in the same locale, or failing that in the same base language (so a
+// request for "fr-CA" can be given a title in "fr" or "fr-FR"). Movies without a title
+// in any of the locales keep their canonical title.
+func (m MovieTitleModel) Localize(movies []*Movie, locales []string) error {
+	if len(movies) == 0 || len(locales) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	bases := make([]string, len(locales))
+	for i, locale := range locales {
+		bases[i] = baseLanguage(locale)
+	}
+
+	// Fetch every title which could match any of the locales, and then choose between
+	// them in order of preference.
+	query := `
+	SELECT movie_id, locale, title
+	FROM movie_titles
+	WHERE movie_id = ANY($1) AND split_part(lower(locale), '-', 1) = ANY($2)
+	ORDER BY is_original DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(bases))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	titles := make(map[int64][]MovieTitle)
+
+	for rows.Next() {
+		var title MovieTitle
+
+		err := rows.Scan(&title.MovieID, &title.Locale, &title.Title)
+		if err != nil {
+			return err
+		}
+
+		titles[title.MovieID] = append(titles[title.MovieID], title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		if title := matchTitle(titles[movie.ID], locales); title != nil {
+			movie.Title = title.Title
+			movie.TitleLocale = title.Locale
+		}
+	}
+
+	return nil
+}
+
+// matchTitle returns the title which best matches the locales, in order of preference,
+// or nil if none of them match.
+func matchTitle(titles []MovieTitle, locales []string) *MovieTitle {
+	for _, locale := range locales {
+		for i := range titles {
+			if strings.EqualFold(titles[i].Locale, locale) {
+				return &titles[i]
+			}
+		}
+
+		for i := range titles {
+			if baseLanguage(titles[i].Locale) == baseLanguage(locale) {
+				return &titles[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// touchMovie bumps the version number of a live movie whose related records have
+// changed, as part of an existing transaction, and records a revision with the given
+// changes. It returns ErrRecordNotFound if there is no live movie with the ID.
+func touchMovie(ctx context.Context, tx *sql.Tx, movieID int64, userID int64, changes map[string]FieldChange) error {
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, nil)
+
+	err := tx.QueryRowContext(ctx, `
+	UPDATE movies
+	SET version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING `+columns, movieID).Scan(dest...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return insertMovieRevision(ctx, tx, &movie, RevisionActionUpdate, userID, changes)
+}
@@ -17,17 +17,20 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
-// scope.
+// scope. Email is only used by email change tokens, and holds the new address that the
+// user wants to switch to.
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Email     string    `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -96,13 +99,29 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
-// Insert() adds the data for a specific token to the tokens table.
+// The NewEmailChange() method creates and inserts an email change token, which stores
+// the new email address alongside the usual token data.
+func (m TokenModel) NewEmailChange(userID int64, ttl time.Duration, email string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Email = email
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// Insert() adds the data for a specific token to the tokens table. We use NULLIF() so
+// that tokens which don't carry an email address store NULL rather than an empty string.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, email)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Email}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/travboz/greenlightv3/internal/data/validator"
@@ -77,6 +78,19 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash is a precomputed bcrypt hash of a value that no user will ever
+// submit, at the same cost (bcrypt.DefaultCost) as real password hashes. It's a constant
+// rather than being generated, so that neither startup nor the first failed login pays
+// for hashing it.
+var dummyPasswordHash = []byte("$2a$10$0PHuXlRZygaVFEG92OvgO.kLElEwb4PCj3dvZpqkYQDKy1pOD2eQC")
+
+// The CompareDummyPassword() function runs a bcrypt comparison against a dummy hash and
+// discards the result. We call this when there is no user for a given email address, so
+// that a failed login takes the same amount of time whether or not the account exists.
+func CompareDummyPassword(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -272,6 +286,53 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 }
 
+// GetForEmailChangeToken() retrieves the user associated with an email change token,
+// along with the new email address that was stored in the token.
+func (m UserModel) GetForEmailChangeToken(tokenPlaintext string) (*User, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, tokens.email
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+		AND tokens.email IS NOT NULL`
+
+	args := []any{tokenHash[:], ScopeEmailChange, time.Now()}
+
+	var (
+		user     User
+		newEmail string
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&newEmail,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	return &user, newEmail, nil
+}
+
 func (m UserModel) GetById(id int64) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
@@ -304,3 +365,95 @@ func (m UserModel) GetById(id int64) (*User, error) {
 
 	return &user, nil
 }
+
+// Delete the record for a specific user. Their tokens and permissions are removed along
+// with it by the ON DELETE CASCADE foreign keys.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll() returns a page of users. The email and name filters match any user whose
+// email or name contains the given text (ignoring case), and the activated filter is
+// ignored when it is nil.
+func (m UserModel) GetAll(email, name string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	// We use strpos() rather than LIKE so that any % or _ characters in the filters are
+	// matched literally.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE (strpos(lower(email), lower($1)) > 0 OR $1 = '')
+		AND (strpos(lower(name), lower($2)) > 0 OR $2 = '')
+		AND (activated = $3 OR $3 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	args := []any{email, name, activated, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
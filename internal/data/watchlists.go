@@ -0,0 +1,480 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+)
+
+// A Watchlist is a user's ordered list of movies. If it is public, anyone with its share
+// slug can view it.
+type Watchlist struct {
+	ID          int64            `json:"id"`
+	UserID      int64            `json:"-"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Public      bool             `json:"public"`
+	ShareSlug   *string          `json:"share_slug,omitempty"`
+	ItemCount   int              `json:"item_count"`
+	Items       []*WatchlistItem `json:"items,omitempty"`
+	Version     int32            `json:"version"`
+}
+
+// A WatchlistItem is a movie in a watchlist, along with its position in the list.
+type WatchlistItem struct {
+	Position int32     `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+func ValidateWatchlist(v *validator.Validator, watchlist *Watchlist) {
+	v.Check(watchlist.Name != "", "name", "must be provided")
+	v.Check(len(watchlist.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(watchlist.Description) <= 2_000, "description", "must not be more than 2000 bytes long")
+}
+
+// generateShareSlug() returns a new random slug for sharing a watchlist. Like our
+// tokens, it holds 16 random bytes, so it can't be guessed.
+func generateShareSlug() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+// Define a WatchlistModel struct which wraps the connection pool.
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates a new watchlist, generating a share slug for it if it is public.
+func (m WatchlistModel) Insert(watchlist *Watchlist) error {
+	if watchlist.Public {
+		slug, err := generateShareSlug()
+		if err != nil {
+			return err
+		}
+
+		watchlist.ShareSlug = &slug
+	}
+
+	query := `
+	INSERT INTO watchlists (user_id, name, description, share_slug)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version`
+
+	args := []any{watchlist.UserID, watchlist.Name, watchlist.Description, watchlist.ShareSlug}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&watchlist.ID, &watchlist.CreatedAt, &watchlist.Version)
+}
+
+// Get() returns one of the given user's watchlists. Watchlists belonging to other users
+// are reported as not found.
+func (m WatchlistModel) Get(id, userID int64) (*Watchlist, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get("w.id = $1 AND w.user_id = $2", id, userID)
+}
+
+// GetBySlug() returns the public watchlist with the given share slug.
+func (m WatchlistModel) GetBySlug(slug string) (*Watchlist, error) {
+	return m.get("w.share_slug = $1", slug)
+}
+
+func (m WatchlistModel) get(where string, args ...any) (*Watchlist, error) {
+	query := fmt.Sprintf(`
+	SELECT w.id, w.user_id, w.created_at, w.name, w.description, w.share_slug, w.version,
+		(SELECT count(*) FROM watchlist_items i INNER JOIN movies m ON m.id = i.movie_id WHERE i.watchlist_id = w.id AND m.deleted_at IS NULL)
+	FROM watchlists w
+	WHERE %s`, where)
+
+	var watchlist Watchlist
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&watchlist.ID,
+		&watchlist.UserID,
+		&watchlist.CreatedAt,
+		&watchlist.Name,
+		&watchlist.Description,
+		&watchlist.ShareSlug,
+		&watchlist.Version,
+		&watchlist.ItemCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	watchlist.Public = watchlist.ShareSlug != nil
+
+	return &watchlist, nil
+}
+
+// GetAllForUser() returns a page of the given user's watchlists, without their items.
+func (m WatchlistModel) GetAllForUser(userID int64, filters Filters) ([]*Watchlist, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), w.id, w.user_id, w.created_at, w.name, w.description, w.share_slug, w.version,
+		(SELECT count(*) FROM watchlist_items i INNER JOIN movies m ON m.id = i.movie_id WHERE i.watchlist_id = w.id AND m.deleted_at IS NULL)
+	FROM watchlists w
+	WHERE w.user_id = $1
+	ORDER BY w.%s %s, w.id ASC
+	LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	watchlists := []*Watchlist{}
+
+	for rows.Next() {
+		var watchlist Watchlist
+
+		err := rows.Scan(
+			&totalRecords,
+			&watchlist.ID,
+			&watchlist.UserID,
+			&watchlist.CreatedAt,
+			&watchlist.Name,
+			&watchlist.Description,
+			&watchlist.ShareSlug,
+			&watchlist.Version,
+			&watchlist.ItemCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		watchlist.Public = watchlist.ShareSlug != nil
+
+		watchlists = append(watchlists, &watchlist)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return watchlists, metadata, nil
+}
+
+// Update() saves changes to a watchlist, using optimistic locking on the version number.
+// Making a watchlist public gives it a share slug (keeping the existing one if it
+// already had one), and making it private removes the slug, so links to it stop
+// working.
+func (m WatchlistModel) Update(watchlist *Watchlist) error {
+	slug, err := generateShareSlug()
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE watchlists
+	SET name = $1, description = $2, share_slug = CASE WHEN $3 THEN coalesce(share_slug, $4) END, version = version + 1
+	WHERE id = $5 AND user_id = $6 AND version = $7
+	RETURNING share_slug, version`
+
+	args := []any{watchlist.Name, watchlist.Description, watchlist.Public, slug, watchlist.ID, watchlist.UserID, watchlist.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&watchlist.ShareSlug, &watchlist.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() removes one of the given user's watchlists, along with its items.
+func (m WatchlistModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM watchlists
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetItems() returns the items in a watchlist in order. Movies in the trash are left
+// out.
+func (m WatchlistModel) GetItems(watchlistID int64) ([]*WatchlistItem, error) {
+	query := `
+	SELECT i.position, i.added_at, m.id, m.title, m.year, m.runtime, m.genres, m.version, m.average_rating, m.rating_count, m.poster, m.poster_thumbnails
+	FROM watchlist_items i
+	INNER JOIN movies m ON m.id = i.movie_id
+	WHERE i.watchlist_id = $1 AND m.deleted_at IS NULL
+	ORDER BY i.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []*WatchlistItem{}
+
+	for rows.Next() {
+		item := WatchlistItem{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&item.Position,
+			&item.AddedAt,
+			&item.Movie.ID,
+			&item.Movie.Title,
+			&item.Movie.Year,
+			&item.Movie.Runtime,
+			pq.Array(&item.Movie.Genres),
+			&item.Movie.Version,
+			&item.Movie.AverageRating,
+			&item.Movie.RatingCount,
+			&item.Movie.Poster.Key,
+			pq.Array(&item.Movie.Poster.Thumbnails),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// SetItem() adds a movie to one of the given user's watchlists at the given position,
+// shifting the items at or after that position down by one. If the movie is already in
+// the watchlist it is moved instead. A position of 0, or one past the end of the list,
+// adds the movie at the end. The final position and the time the movie was first added
+// are set on item.
+func (m WatchlistModel) SetItem(watchlistID, userID int64, item *WatchlistItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed, so it's safe to
+	// defer it straight away.
+	defer tx.Rollback()
+
+	// Lock the watchlist, so that concurrent changes to its items are applied one at a
+	// time, and check that it belongs to the user.
+	err = lockWatchlist(ctx, tx, watchlistID, userID)
+	if err != nil {
+		return err
+	}
+
+	// If the movie is already in the list, take it out (closing the gap it leaves) but
+	// remember when it was added.
+	var addedAt *time.Time
+
+	err = removeWatchlistItem(ctx, tx, watchlistID, item.Movie.ID, &addedAt)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+
+	var end int32
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT coalesce(max(position), 0) + 1
+	FROM watchlist_items
+	WHERE watchlist_id = $1`, watchlistID).Scan(&end)
+	if err != nil {
+		return err
+	}
+
+	if item.Position < 1 || item.Position > end {
+		item.Position = end
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE watchlist_items
+	SET position = position + 1
+	WHERE watchlist_id = $1 AND position >= $2`, watchlistID, item.Position)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO watchlist_items (watchlist_id, movie_id, position, added_at)
+	VALUES ($1, $2, $3, coalesce($4, NOW()))
+	RETURNING added_at`, watchlistID, item.Movie.ID, item.Position, addedAt).Scan(&item.AddedAt)
+	if err != nil {
+		return err
+	}
+
+	err = touchWatchlist(ctx, tx, watchlistID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveItem() removes a movie from one of the given user's watchlists, closing the
+// gap it leaves.
+func (m WatchlistModel) RemoveItem(watchlistID, userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, watchlistID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = removeWatchlistItem(ctx, tx, watchlistID, movieID, nil)
+	if err != nil {
+		return err
+	}
+
+	err = touchWatchlist(ctx, tx, watchlistID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockWatchlist locks a watchlist for the rest of the transaction, returning
+// ErrRecordNotFound if it doesn't exist or doesn't belong to the user.
+func lockWatchlist(ctx context.Context, tx *sql.Tx, watchlistID, userID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `
+	SELECT id
+	FROM watchlists
+	WHERE id = $1 AND user_id = $2
+	FOR UPDATE`, watchlistID, userID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeWatchlistItem deletes a movie from a watchlist and moves the items after it up
+// by one. If addedAt isn't nil, it is set to the time the movie was added. It returns
+// ErrRecordNotFound if the movie wasn't in the watchlist.
+func removeWatchlistItem(ctx context.Context, tx *sql.Tx, watchlistID, movieID int64, addedAt **time.Time) error {
+	var (
+		position int32
+		added    time.Time
+	)
+
+	err := tx.QueryRowContext(ctx, `
+	DELETE FROM watchlist_items
+	WHERE watchlist_id = $1 AND movie_id = $2
+	RETURNING position, added_at`, watchlistID, movieID).Scan(&position, &added)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if addedAt != nil {
+		*addedAt = &added
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE watchlist_items
+	SET position = position - 1
+	WHERE watchlist_id = $1 AND position > $2`, watchlistID, position)
+
+	return err
+}
+
+// touchWatchlist bumps the version number of a watchlist after its items have changed.
+func touchWatchlist(ctx context.Context, tx *sql.Tx, watchlistID int64) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE watchlists
+	SET version = version + 1
+	WHERE id = $1`, watchlistID)
+
+	return err
+}
@@ -0,0 +1,479 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/travboz/greenlightv3/internal/data/validator"
+	"github.com/travboz/greenlightv3/internal/webhook"
+)
+
+// Define constants for the status of a webhook delivery. Pending deliveries are retried
+// until they're delivered or run out of attempts, at which point they've failed.
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+// A Webhook is a URL which movie events are delivered to. An empty Events slice
+// subscribes it to every type of event. Secret is used to sign the deliveries, and is
+// only included in responses when it's first generated.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+// A WebhookDelivery tracks the delivery of one event to one webhook. NextAttemptAt is
+// only set while the delivery is pending, and ResponseStatus and Error describe the
+// most recent attempt.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	WebhookID      int64      `json:"webhook_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// A DueDelivery is a pending delivery which has been claimed for another attempt,
+// along with everything needed to make it.
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+	Event  MovieEvent
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2_000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	if err == nil {
+		v.Check(publicHost(u.Hostname()), "url", "must not point to a loopback, private or link-local address")
+	}
+
+	v.Check(webhook.Events != nil, "events", "must be provided")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(slices.Contains(EventTypes, event), "events", "must only contain known event types")
+	}
+}
+
+// publicHost() reports whether a webhook URL's host could be on the public internet.
+// IP addresses are checked with webhook.PublicAddr(), and "localhost" is rejected.
+// Other host names are checked when they're resolved, as each delivery is made.
+func publicHost(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		return webhook.PublicAddr(addr)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+// Check that the status filter (if provided) is one of our known delivery statuses.
+func ValidateDeliveryStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, "", DeliveryStatusPending, DeliveryStatusDelivered, DeliveryStatusFailed), "status", "invalid status value")
+}
+
+// generateWebhookSecret() returns a new random secret for signing a webhook's
+// deliveries.
+func generateWebhookSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// Define a WebhookModel struct which wraps the connection pool.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates a new webhook with a newly generated secret.
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+
+	webhook.Secret = secret
+
+	query := `
+	INSERT INTO webhooks (url, secret, events, active)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version`
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get() returns a webhook, without its secret.
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, url, events, active, version
+	FROM webhooks
+	WHERE id = $1`
+
+	var webhook Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// GetAll() returns a page of webhooks, without their secrets.
+func (m WebhookModel) GetAll(filters Filters) ([]*Webhook, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, url, events, active, version
+	FROM webhooks
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&totalRecords,
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return webhooks, metadata, nil
+}
+
+// Update() saves the URL, events and active flag of a webhook, using optimistic
+// locking on its version. If rotateSecret is true, a new secret is generated and
+// stored in webhook.Secret.
+func (m WebhookModel) Update(webhook *Webhook, rotateSecret bool) error {
+	var secret *string
+
+	if rotateSecret {
+		s, err := generateWebhookSecret()
+		if err != nil {
+			return err
+		}
+
+		secret = &s
+	}
+
+	query := `
+	UPDATE webhooks
+	SET url = $1, events = $2, active = $3, secret = coalesce($4, secret), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version`
+
+	args := []any{webhook.URL, pq.Array(webhook.Events), webhook.Active, secret, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if secret != nil {
+		webhook.Secret = *secret
+	}
+
+	return nil
+}
+
+// Delete() removes a webhook, along with its deliveries.
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM webhooks
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetDeliveries() returns a page of a webhook's deliveries, optionally filtered by
+// status. An empty status matches everything.
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), d.id, d.created_at, d.updated_at, d.webhook_id, d.event_id, e.type,
+		d.status, d.attempts, CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.response_status, d.error
+	FROM webhook_deliveries d
+	INNER JOIN movie_events e ON e.id = d.event_id
+	WHERE d.webhook_id = $1
+	AND (d.status = $2 OR $2 = '')
+	ORDER BY d.%s %s, d.id ASC
+	LIMIT $3 OFFSET $4`,
+		filters.sortColumn(), filters.sortDirection(),
+	)
+
+	args := []any{webhookID, status, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.UpdatedAt,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.ResponseStatus,
+			&delivery.Error,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// ClaimDue() claims up to limit pending deliveries to active webhooks which are due
+// another attempt, oldest first. Claiming a delivery pushes its next attempt back by
+// the lease, so that other workers leave it alone while it's being attempted, and so
+// that it's retried if the worker dies before recording the outcome.
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*DueDelivery, error) {
+	query := `
+	WITH claimed AS (
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT d.id
+			FROM webhook_deliveries d
+			INNER JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.active
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING id, created_at, updated_at, webhook_id, event_id, status, attempts, next_attempt_at
+	)
+	SELECT c.id, c.created_at, c.updated_at, c.webhook_id, c.event_id, c.status, c.attempts, c.next_attempt_at,
+		w.url, w.secret, e.id, e.type, e.created_at, e.movie_id, e.version, e.snapshot, e.changes
+	FROM claimed c
+	INNER JOIN webhooks w ON w.id = c.webhook_id
+	INNER JOIN movie_events e ON e.id = c.event_id
+	ORDER BY e.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*DueDelivery{}
+
+	for rows.Next() {
+		var d DueDelivery
+
+		err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.WebhookID,
+			&d.EventID,
+			&d.Status,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&d.URL,
+			&d.Secret,
+			&d.Event.ID,
+			&d.Event.Type,
+			&d.Event.CreatedAt,
+			&d.Event.MovieID,
+			&d.Event.Version,
+			&d.Event.Snapshot,
+			&d.Event.Changes,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		d.EventType = d.Event.Type
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// UpdateDelivery() records the outcome of an attempt to deliver an event: its status,
+// attempt count, when it's next due (for pending deliveries), and the response status
+// and error from the attempt.
+func (m WebhookModel) UpdateDelivery(delivery *WebhookDelivery) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = $1, attempts = $2, next_attempt_at = coalesce($3, next_attempt_at), response_status = $4, error = $5, updated_at = NOW()
+	WHERE id = $6
+	RETURNING updated_at`
+
+	args := []any{
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.ResponseStatus,
+		delivery.Error,
+		delivery.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DatabaseTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
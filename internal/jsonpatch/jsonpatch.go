@@ -0,0 +1,468 @@
+// Package jsonpatch implements JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// for JSON documents.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Define the media types used for each kind of patch.
+const (
+	MergePatchMediaType = "application/merge-patch+json"
+	PatchMediaType      = "application/json-patch+json"
+)
+
+// ErrInvalidDocument is returned if the document or patch being applied isn't valid
+// JSON.
+var ErrInvalidDocument = errors.New("invalid JSON document")
+
+// An Operation is a single JSON Patch operation. Value is left nil if the operation
+// doesn't have a "value" member, which lets us distinguish a missing value from an
+// explicit null.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// A Patch is a sequence of JSON Patch operations, which are applied in order.
+type Patch []Operation
+
+// An OperationError is returned by Patch.Apply() if one of the operations can't be
+// applied. Index is the position of the operation in the patch.
+type OperationError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s %q): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+// DecodePatch() parses a JSON Patch document.
+func DecodePatch(b []byte) (Patch, error) {
+	var patch Patch
+
+	err := json.Unmarshal(b, &patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+
+	return patch, nil
+}
+
+// Apply() applies the patch to a JSON document and returns the patched document. The
+// patch is atomic: if any operation fails, an *OperationError is returned and none of
+// the changes are kept.
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range p {
+		root, err = op.apply(root)
+		if err != nil {
+			return nil, &OperationError{Index: i, Op: op.Op, Path: op.Path, Err: err.Error()}
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func (op Operation) apply(root any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New(`missing "value" member`)
+		}
+
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			return replace(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !equal(current, value) {
+				return nil, errors.New("test failed: value does not match")
+			}
+
+			return root, nil
+		}
+
+	case "remove":
+		root, _, err = remove(root, path)
+		return root, err
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+
+		value, err := get(root, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		if op.Op == "copy" {
+			return add(root, path, deepCopy(value))
+		}
+
+		// A value can't be moved into one of its own children.
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("cannot move a value into one of its children")
+		}
+
+		root, _, err = remove(root, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return add(root, path, value)
+
+	case "":
+		return nil, errors.New(`missing "op" member`)
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// MergePatch() applies a JSON Merge Patch to a JSON document and returns the patched
+// document. Members of the patch which are null are removed from the document, objects
+// are merged recursively, and any other value replaces the existing one.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+
+		t[key] = merge(t[key], value)
+	}
+
+	return t
+}
+
+// decode() parses a JSON value, keeping numbers as json.Number so that they round-trip
+// without losing precision.
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v any
+
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, ErrInvalidDocument
+	}
+
+	if dec.More() {
+		return nil, ErrInvalidDocument
+	}
+
+	return v, nil
+}
+
+// parsePointer() splits a JSON Pointer (RFC 6901) into its unescaped reference tokens.
+// The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with \"/\"", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex() parses an array index token. If end is true, the "-" token (referring
+// to the position after the last element) and an index equal to the length of the
+// array are allowed.
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if token == "-" && end {
+		return length, nil
+	}
+
+	// Leading zeros aren't allowed by RFC 6901.
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	if i > length || (i == length && !end) {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+
+	return i, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q in a scalar value", token)
+		}
+	}
+
+	return node, nil
+}
+
+// modify() walks to the container holding the last token in path, calls fn with it,
+// and returns the root with the container replaced by the value that fn returns. We
+// need to rebuild the path on the way back up because inserting into or removing from
+// a slice can change the slice header.
+func modify(node any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", path[0])
+		}
+
+		child, err := modify(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		n[path[0]] = child
+		return n, nil
+
+	case []any:
+		i, err := arrayIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := modify(n[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		n[i] = child
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("cannot reference %q in a scalar value", path[0])
+	}
+}
+
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return modify(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a scalar value", token)
+		}
+	})
+}
+
+func remove(root any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+
+	var removed any
+
+	root, err := modify(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			value, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			removed = value
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a scalar value", token)
+		}
+	})
+
+	return root, removed, err
+}
+
+func replace(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return modify(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			c[token] = value
+			return c, nil
+		case []any:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot replace %q in a scalar value", token)
+		}
+	})
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, child := range v {
+			m[key] = deepCopy(child)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, child := range v {
+			s[i] = deepCopy(child)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// equal() compares two decoded JSON values as described for the "test" operation in
+// RFC 6902. Numbers are equal if their values are, so 1 and 1.0 match.
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,166 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const movie = `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`
+
+// assertJSON checks that two JSON documents are equivalent, ignoring formatting and
+// the order of object members.
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	if string(gb) != string(wb) {
+		t.Errorf("got %s, want %s", gb, wb)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "replace member",
+			patch: `{"title": "Moana 2"}`,
+			want:  `{"title": "Moana 2", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`,
+		},
+		{
+			name:  "null removes member",
+			patch: `{"runtime": null}`,
+			want:  `{"title": "Moana", "year": 2016, "genres": ["animation", "adventure"]}`,
+		},
+		{
+			name:  "arrays are replaced",
+			patch: `{"genres": ["musical"]}`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["musical"]}`,
+		},
+		{
+			name:  "non-object replaces document",
+			patch: `["a"]`,
+			want:  `["a"]`,
+		},
+		{
+			name:  "nested objects merge",
+			patch: `{"extra": {"a": 1, "b": null}}`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"], "extra": {"a": 1}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(movie), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "append genre",
+			patch: `[{"op": "add", "path": "/genres/-", "value": "musical"}]`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure", "musical"]}`,
+		},
+		{
+			name:  "insert genre",
+			patch: `[{"op": "add", "path": "/genres/0", "value": "musical"}]`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["musical", "animation", "adventure"]}`,
+		},
+		{
+			name:  "remove genre",
+			patch: `[{"op": "remove", "path": "/genres/0"}]`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["adventure"]}`,
+		},
+		{
+			name:  "test then replace",
+			patch: `[{"op": "test", "path": "/year", "value": 2016.0}, {"op": "replace", "path": "/year", "value": 2017}]`,
+			want:  `{"title": "Moana", "year": 2017, "runtime": "107 mins", "genres": ["animation", "adventure"]}`,
+		},
+		{
+			name:  "move and copy",
+			patch: `[{"op": "copy", "from": "/title", "path": "/genres/-"}, {"op": "move", "from": "/runtime", "path": "/length"}]`,
+			want:  `{"title": "Moana", "year": 2016, "length": "107 mins", "genres": ["animation", "adventure", "Moana"]}`,
+		},
+		{
+			name:  "escaped pointer",
+			patch: `[{"op": "add", "path": "/a~1b~0c", "value": null}]`,
+			want:  `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"], "a/b~c": null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := patch.Apply([]byte(movie))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestPatchApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		index int
+	}{
+		{name: "unknown op", patch: `[{"op": "frobnicate", "path": "/title"}]`},
+		{name: "missing value", patch: `[{"op": "add", "path": "/title"}]`},
+		{name: "missing member", patch: `[{"op": "remove", "path": "/director"}]`},
+		{name: "index out of range", patch: `[{"op": "replace", "path": "/genres/2", "value": "x"}]`},
+		{name: "leading zero", patch: `[{"op": "remove", "path": "/genres/01"}]`},
+		{name: "failed test", patch: `[{"op": "replace", "path": "/year", "value": 2017}, {"op": "test", "path": "/title", "value": "Frozen"}]`, index: 1},
+		{name: "move into child", patch: `[{"op": "move", "from": "/genres", "path": "/genres/0"}]`},
+		{name: "bad pointer", patch: `[{"op": "remove", "path": "title"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = patch.Apply([]byte(movie))
+
+			var opErr *OperationError
+			if !errors.As(err, &opErr) {
+				t.Fatalf("wanted an *OperationError, got %v", err)
+			}
+
+			if opErr.Index != tt.index {
+				t.Errorf("wanted error for operation %d, got %d", tt.index, opErr.Index)
+			}
+		})
+	}
+}
@@ -35,13 +35,14 @@ func New(host string, port int, username, password, sender string) Mailer {
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// dynamic data for the templates as an any parameter. It returns the number of
+// delivery attempts that were made, so that callers can record it.
+func (m Mailer) Send(recipient, templateFile string, data any) (int, error) {
 	// Use the ParseFS() method to parse the required template file from the embedded
 	// file system.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Execute the named template "subject", passing in the dynamic data and storing the
@@ -49,7 +50,7 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Follow the same pattern to execute the "plainBody" template and store the result
@@ -57,14 +58,14 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
@@ -85,16 +86,19 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	// error.
 	// Try sending the email up to three times before aborting and returning the final
 	// error. We sleep for 500 milliseconds between each attempt.
+	attempts := 0
 	for i := 1; i <= 3; i++ {
+		attempts = i
+
 		err = m.dialer.DialAndSend(msg)
 		// If everything worked, return nil.
 		if nil == err {
-			return nil
+			return attempts, nil
 		}
 
 		// If it didn't work, sleep for a short time and retry.
 		time.Sleep(500 * time.Millisecond)
 	}
 
-	return err
+	return attempts, err
 }
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a Store which keeps blobs as files under a directory on the local
+// filesystem.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore() returns a FileStore which keeps its blobs under dir, creating the
+// directory if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// path() converts a key to the path of its file. Keys must be relative and must not
+// climb out of the store's directory with "..".
+func (s *FileStore) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+
+	if key == "" || !filepath.IsLocal(name) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put() writes the blob to a temporary file and then renames it into place, so that
+// readers never see a partially written blob.
+func (s *FileStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file if anything goes wrong. Once it has been renamed this
+	// is a no-op.
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(ctx, "posters/1/a.jpg", strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Putting the same key again replaces the blob.
+	err = store.Put(ctx, "posters/1/a.jpg", strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := store.Get(ctx, "posters/1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "second" {
+		t.Errorf("got %q, want %q", got, "second")
+	}
+
+	err = store.Delete(ctx, "posters/1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get(ctx, "posters/1/a.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v after delete, want ErrNotFound", err)
+	}
+
+	// Deleting a missing blob isn't an error.
+	err = store.Delete(ctx, "posters/1/a.jpg")
+	if err != nil {
+		t.Errorf("got error %v deleting a missing blob", err)
+	}
+}
+
+func TestFileStoreInvalidKeys(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../outside", "posters/../../outside"} {
+		_, err := store.Get(context.Background(), key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): got error %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
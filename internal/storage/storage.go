@@ -0,0 +1,29 @@
+// Package storage provides blob stores for uploaded files, such as movie posters.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Define the errors returned by blob stores.
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// A Store holds blobs, identified by slash-separated keys like "posters/12/a1b2.jpg".
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Put() saves the contents of r under the given key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Get() opens the blob with the given key for reading. The caller must close it.
+	// It returns ErrNotFound if there is no such blob.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete() removes the blob with the given key. Deleting a blob which doesn't
+	// exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,278 @@
+// Package webhook signs and delivers webhook requests.
+//
+// Each request is a POST of a JSON body, signed with HMAC-SHA256 using a secret shared
+// with the receiver. The signature header holds the time that the request was signed
+// and the hex-encoded signature of "<timestamp>.<body>", like so:
+//
+//	Greenlight-Signature: t=1700000000,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// Including the timestamp in the signature lets receivers reject old requests which
+// are being replayed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Define the headers sent with each request. The event ID is the same for every
+// attempt to deliver an event, so receivers can use it to ignore duplicates.
+const (
+	SignatureHeader = "Greenlight-Signature"
+	EventIDHeader   = "Greenlight-Event-Id"
+	EventTypeHeader = "Greenlight-Event-Type"
+)
+
+// Define the delays between attempts to deliver a request. The delay doubles after
+// each failed attempt, up to the maximum.
+const (
+	minBackoff = 30 * time.Second
+	maxBackoff = 6 * time.Hour
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrExpiredSignature = errors.New("webhook: signature has expired")
+	ErrNonPublicAddress = errors.New("webhook: receiver address is not public")
+)
+
+// nonPublicPrefixes holds the special-purpose ranges which PublicAddr() rejects on top
+// of the ones that netip.Addr has methods for.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+}
+
+// PublicAddr() reports whether an IP address can be reached over the public internet.
+// Loopback, private, link-local (including cloud metadata services, such as
+// 169.254.169.254), unspecified and multicast addresses aren't public, so webhooks
+// can't be used to make requests to the server's own network.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsValid() ||
+		addr.IsUnspecified() ||
+		addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A StatusError is returned by Send() when the receiver responds with a status code
+// outside of the 2xx range.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook: receiver responded with status %d", e.Code)
+}
+
+// Sign() returns the value of the signature header for a request body signed with the
+// given secret at the given time.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signature(secret, timestamp, body))
+}
+
+// Verify() checks the value of a signature header against a request body. It returns
+// ErrExpiredSignature if the request was signed more than tolerance before now, and
+// ErrInvalidSignature if the header is malformed or none of its signatures match.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var (
+		timestamp  string
+		signatures []string
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(secret, timestamp, body)
+
+	valid := false
+
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	if now.Sub(time.Unix(seconds, 0)) > tolerance {
+		return ErrExpiredSignature
+	}
+
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff() returns how long to wait before the next attempt to deliver a request,
+// given the number of attempts made so far. The delay doubles with each attempt, and
+// is jittered by up to half so that failed deliveries don't all retry at once.
+func Backoff(attempts int) time.Duration {
+	delay := maxBackoff
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	if attempts <= 20 {
+		delay = min(minBackoff<<(attempts-1), maxBackoff)
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// A Message is a single request to deliver to a webhook.
+type Message struct {
+	URL       string
+	Secret    string
+	EventID   int64
+	EventType string
+	Body      []byte
+}
+
+// A Client delivers signed requests to webhooks.
+type Client struct {
+	http      *http.Client
+	userAgent string
+
+	// allowNonPublic turns off the check on the addresses that the client connects
+	// to, so that the tests can deliver to receivers on the loopback interface.
+	allowNonPublic bool
+}
+
+// New() returns a Client which gives up on each request after the given timeout.
+// Redirects aren't followed, so a receiver can't bounce a signed request to another
+// URL. The client refuses to connect to addresses which aren't public (see
+// PublicAddr()). The check is made on each address that a host name resolves to as it
+// is dialled, so a receiver can't get around it with DNS records which change after
+// its URL was validated. Requests don't go through a proxy, as the check would then
+// apply to the proxy rather than the receiver.
+func New(timeout time.Duration, userAgent string) *Client {
+	c := &Client{userAgent: userAgent}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   c.checkAddress,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	c.http = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return c
+}
+
+// checkAddress() is the dialer's Control function, which is called with the resolved
+// address of each connection before it's made. It returns ErrNonPublicAddress if the
+// address isn't public.
+func (c *Client) checkAddress(network, address string, conn syscall.RawConn) error {
+	if c.allowNonPublic {
+		return nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !PublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, addrPort.Addr())
+	}
+
+	return nil
+}
+
+// Send() makes a single attempt to deliver a message. It returns the status code of
+// the response (or 0 if there wasn't one), and a *StatusError if the status code
+// wasn't in the 2xx range. Retrying failed deliveries is left to the caller.
+func (c *Client) Send(ctx context.Context, msg Message) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set(SignatureHeader, Sign(msg.Secret, time.Now(), msg.Body))
+	req.Header.Set(EventIDHeader, strconv.FormatInt(msg.EventID, 10))
+	req.Header.Set(EventTypeHeader, msg.EventType)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	// Read (some of) the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &StatusError{Code: resp.StatusCode}
+	}
+
+	return resp.StatusCode, nil
+}
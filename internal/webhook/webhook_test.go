@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	const secret = "s3cret"
+
+	type request struct {
+		header http.Header
+		body   []byte
+		err    error
+	}
+
+	requests := make(chan request, 1)
+
+	// The receiver verifies each request in the same way that a real one should.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Now(), 5*time.Minute)
+		requests <- request{header: r.Header, body: body, err: err}
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	client := New(5*time.Second, "greenlight-test")
+	client.allowNonPublic = true
+
+	msg := Message{
+		URL:       srv.URL,
+		Secret:    secret,
+		EventID:   42,
+		EventType: "movie.created",
+		Body:      []byte(`{"id":42}`),
+	}
+
+	status, err := client.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != http.StatusOK {
+		t.Errorf("got status %d, want %d", status, http.StatusOK)
+	}
+
+	got := <-requests
+
+	if got.err != nil {
+		t.Errorf("receiver failed to verify the signature: %v", got.err)
+	}
+
+	if string(got.body) != string(msg.Body) {
+		t.Errorf("got body %q, want %q", got.body, msg.Body)
+	}
+
+	if id := got.header.Get(EventIDHeader); id != "42" {
+		t.Errorf("got event ID %q, want %q", id, "42")
+	}
+
+	if typ := got.header.Get(EventTypeHeader); typ != "movie.created" {
+		t.Errorf("got event type %q, want %q", typ, "movie.created")
+	}
+
+	// A request signed with the wrong secret is rejected by the receiver, which the
+	// client reports as a StatusError.
+	msg.Secret = "wrong"
+
+	status, err = client.Send(context.Background(), msg)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnauthorized {
+		t.Errorf("got %v, want a StatusError with code %d", err, http.StatusUnauthorized)
+	}
+
+	if status != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if got := <-requests; !errors.Is(got.err, ErrInvalidSignature) {
+		t.Errorf("got %v, want ErrInvalidSignature", got.err)
+	}
+}
+
+func TestSendRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect was followed")
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer srv.Close()
+
+	client := New(5*time.Second, "greenlight-test")
+	client.allowNonPublic = true
+
+	_, err := client.Send(context.Background(), Message{URL: srv.URL, Body: []byte("{}")})
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("got %v, want a StatusError with code %d", err, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestSendNonPublicAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request reached a receiver on the loopback interface")
+	}))
+	defer srv.Close()
+
+	status, err := New(5*time.Second, "greenlight-test").Send(context.Background(), Message{URL: srv.URL, Body: []byte("{}")})
+
+	if !errors.Is(err, ErrNonPublicAddress) {
+		t.Errorf("got %v, want ErrNonPublicAddress", err)
+	}
+
+	if status != 0 {
+		t.Errorf("got status %d, want 0", status)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	signed := time.Unix(1_700_000_000, 0)
+	header := Sign("secret", signed, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"Valid", "secret", header, body, signed.Add(time.Minute), nil},
+		{"Wrong secret", "other", header, body, signed, ErrInvalidSignature},
+		{"Modified body", "secret", header, []byte(`{"id":2}`), signed, ErrInvalidSignature},
+		{"Modified timestamp", "secret", "t=1700000001" + header[12:], body, signed, ErrInvalidSignature},
+		{"Expired", "secret", header, body, signed.Add(10 * time.Minute), ErrExpiredSignature},
+		{"Malformed", "secret", "v1=abc", body, signed, ErrInvalidSignature},
+		{"Empty", "secret", "", body, signed, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{0, minBackoff},
+		{1, minBackoff},
+		{2, 2 * minBackoff},
+		{5, 16 * minBackoff},
+		{20, maxBackoff},
+		{1_000, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		for range 100 {
+			got := Backoff(tt.attempts)
+
+			if got < tt.max/2 || got > tt.max {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempts, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS emails;
+
+DELETE FROM permissions WHERE code = 'emails:view';
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS emails (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    recipient citext NOT NULL,
+    template text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    error text NOT NULL DEFAULT '',
+    attempts integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS emails_recipient_idx ON emails (recipient);
+
+-- Add the permission for viewing the email audit log.
+INSERT INTO
+    permissions (code)
+VALUES
+    ('emails:view');
@@ -0,0 +1,5 @@
+-- Email change tokens need to remember which address the user asked to change to.
+ALTER TABLE
+    tokens
+ADD
+    COLUMN IF NOT EXISTS email citext;
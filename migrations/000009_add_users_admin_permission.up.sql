@@ -0,0 +1,5 @@
+-- Add the permission for managing other users' accounts.
+INSERT INTO
+    permissions (code)
+VALUES
+    ('users:admin');
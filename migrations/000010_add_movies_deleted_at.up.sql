@@ -0,0 +1,8 @@
+ALTER TABLE
+    movies
+ADD
+    COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Only trashed movies are looked up by deleted_at (for the trash listing and the
+-- background purge), so a partial index keeps this small.
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
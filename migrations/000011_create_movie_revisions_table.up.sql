@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    snapshot jsonb NOT NULL,
+    changes jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_idx ON movie_revisions (movie_id, version);
+
+-- Give existing movies an initial revision holding their current state, so that they
+-- have a history to revert to.
+INSERT INTO
+    movie_revisions (movie_id, version, action, created_at, snapshot)
+SELECT
+    id,
+    version,
+    'insert',
+    created_at,
+    jsonb_build_object(
+        'title', title,
+        'year', year,
+        'runtime', runtime || ' mins',
+        'genres', genres
+    )
+FROM
+    movies;
@@ -0,0 +1,99 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Convert the free-text genres on existing movies into slugs (lowercase, with runs of
+-- other characters replaced by a hyphen), keeping the first occurrence of any genres
+-- which now have the same slug. Genres without any letters or digits would have an
+-- empty slug, so they're dropped, and movies which are left without any genres get the
+-- "uncategorized" genre instead, so that they still pass validation when they're next
+-- edited. Each movie which changes gets a new version and a revision recording the
+-- change to its genres, like any other edit.
+WITH slugged AS (
+    SELECT
+        movies.id,
+        movies.genres AS old_genres,
+        COALESCE(
+            NULLIF(
+                ARRAY(
+                    SELECT
+                        slug
+                    FROM
+                        (
+                            SELECT
+                                trim(
+                                    BOTH '-'
+                                    FROM
+                                        regexp_replace(lower(genre), '[^a-z0-9]+', '-', 'g')
+                                ) AS slug,
+                                min(position) AS position
+                            FROM
+                                unnest(movies.genres) WITH ORDINALITY AS g(genre, position)
+                            GROUP BY
+                                1
+                        ) AS slugs
+                    WHERE
+                        slug <> ''
+                    ORDER BY
+                        position
+                ),
+                '{}'
+            ),
+            '{uncategorized}'
+        ) AS new_genres
+    FROM
+        movies
+),
+updated AS (
+    UPDATE
+        movies
+    SET
+        genres = slugged.new_genres,
+        version = movies.version + 1
+    FROM
+        slugged
+    WHERE
+        movies.id = slugged.id
+        AND movies.genres <> slugged.new_genres
+    RETURNING
+        movies.id,
+        movies.version,
+        movies.title,
+        movies.year,
+        movies.runtime,
+        movies.genres,
+        slugged.old_genres
+)
+INSERT INTO
+    movie_revisions (movie_id, version, action, snapshot, changes)
+SELECT
+    id,
+    version,
+    'update',
+    jsonb_build_object(
+        'title', title,
+        'year', year,
+        'runtime', runtime || ' mins',
+        'genres', genres
+    ),
+    jsonb_build_object(
+        'genres', jsonb_build_object('from', old_genres, 'to', genres)
+    )
+FROM
+    updated;
+
+-- Backfill the taxonomy from the genres in use (including "uncategorized", if any
+-- movie was given it above), deriving the display name from the slug.
+INSERT INTO
+    genres (slug, name)
+SELECT
+    DISTINCT genre,
+    initcap(replace(genre, '-', ' '))
+FROM
+    movies,
+    unnest(genres) AS genre
+ON CONFLICT (slug) DO NOTHING;
\ No newline at end of file
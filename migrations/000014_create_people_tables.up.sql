@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    biography text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people (lower(name));
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL,
+    character text NOT NULL DEFAULT '',
+    billing integer NOT NULL DEFAULT 0,
+    UNIQUE (movie_id, person_id, role, character)
+);
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
\ No newline at end of file
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS reviews;
+DROP TABLE IF EXISTS ratings;
+DROP FUNCTION IF EXISTS update_movie_rating;
+DROP INDEX IF EXISTS movies_average_rating_idx;
+ALTER TABLE
+    movies DROP COLUMN IF EXISTS average_rating,
+    DROP COLUMN IF EXISTS rating_total,
+    DROP COLUMN IF EXISTS rating_count;
\ No newline at end of file
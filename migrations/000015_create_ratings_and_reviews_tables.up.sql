@@ -0,0 +1,64 @@
+CREATE TABLE IF NOT EXISTS ratings (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    rating integer NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS ratings_movie_id_idx ON ratings (movie_id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews (movie_id, created_at);
+
+-- The rating aggregates live on the movies table so that they can be returned and
+-- sorted on without touching the ratings. The trigger below keeps the count and total
+-- up to date, and the average is derived from them.
+ALTER TABLE
+    movies
+ADD
+    COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0,
+ADD
+    COLUMN IF NOT EXISTS rating_total bigint NOT NULL DEFAULT 0,
+ADD
+    COLUMN IF NOT EXISTS average_rating numeric(4, 2) GENERATED ALWAYS AS (
+        CASE
+            WHEN rating_count > 0 THEN round(rating_total::numeric / rating_count, 2)
+            ELSE 0
+        END
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, rating_count);
+
+CREATE OR REPLACE FUNCTION update_movie_rating() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movies
+        SET rating_count = rating_count - 1, rating_total = rating_total - OLD.rating
+        WHERE id = OLD.movie_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE movies
+        SET rating_count = rating_count + 1, rating_total = rating_total + NEW.rating
+        WHERE id = NEW.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ratings_update_movie_rating
+AFTER INSERT OR UPDATE OR DELETE ON ratings
+FOR EACH ROW EXECUTE FUNCTION update_movie_rating();
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS watchlists (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    share_slug text UNIQUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS watchlists_user_id_idx ON watchlists (user_id);
+
+-- The items in a watchlist are ordered by position, starting from 1. Adding or moving
+-- an item shifts the others along in a single UPDATE, so the uniqueness check on the
+-- positions is deferred to the end of the transaction.
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    watchlist_id bigint NOT NULL REFERENCES watchlists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (watchlist_id, movie_id),
+    UNIQUE (watchlist_id, position) DEFERRABLE INITIALLY DEFERRED
+);
\ No newline at end of file
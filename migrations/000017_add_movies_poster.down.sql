@@ -0,0 +1,3 @@
+ALTER TABLE
+    movies DROP COLUMN IF EXISTS poster_thumbnails,
+    DROP COLUMN IF EXISTS poster;
\ No newline at end of file
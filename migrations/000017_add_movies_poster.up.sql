@@ -0,0 +1,6 @@
+ALTER TABLE
+    movies
+ADD
+    COLUMN IF NOT EXISTS poster text NOT NULL DEFAULT '',
+ADD
+    COLUMN IF NOT EXISTS poster_thumbnails text [] NOT NULL DEFAULT '{}';
\ No newline at end of file
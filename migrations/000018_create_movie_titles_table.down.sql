@@ -0,0 +1 @@
+DROP TABLE IF EXISTS movie_titles;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_titles (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL,
+    title text NOT NULL,
+    is_original boolean NOT NULL DEFAULT false,
+    UNIQUE (movie_id, locale, title)
+);
+
+-- A movie can only have one original title.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_titles_original_idx ON movie_titles (movie_id) WHERE is_original;
+
+-- The alternate titles are searched in the same way as the movie titles.
+CREATE INDEX IF NOT EXISTS movie_titles_title_idx ON movie_titles USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_trgm_idx ON movie_titles USING GIN (title gin_trgm_ops);
\ No newline at end of file
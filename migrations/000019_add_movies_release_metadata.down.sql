@@ -0,0 +1,17 @@
+DROP TRIGGER IF EXISTS movies_check_year ON movies;
+DROP FUNCTION IF EXISTS check_movie_year;
+ALTER TABLE
+    movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE
+    movies
+ADD
+    CONSTRAINT movies_year_check CHECK (
+        year BETWEEN 1888
+        AND date_part('year', now())
+    );
+DROP INDEX IF EXISTS movies_tmdb_id_idx;
+DROP INDEX IF EXISTS movies_imdb_id_idx;
+ALTER TABLE
+    movies DROP COLUMN IF EXISTS releases,
+    DROP COLUMN IF EXISTS tmdb_id,
+    DROP COLUMN IF EXISTS imdb_id;
\ No newline at end of file
@@ -0,0 +1,53 @@
+ALTER TABLE
+    movies
+ADD
+    COLUMN IF NOT EXISTS imdb_id text NOT NULL DEFAULT '',
+ADD
+    COLUMN IF NOT EXISTS tmdb_id bigint NOT NULL DEFAULT 0,
+ADD
+    COLUMN IF NOT EXISTS releases jsonb NOT NULL DEFAULT '[]';
+
+ALTER TABLE
+    movies
+ADD
+    CONSTRAINT movies_imdb_id_check CHECK (
+        imdb_id = ''
+        OR imdb_id ~ '^tt[0-9]{7,10}$'
+    ),
+ADD
+    CONSTRAINT movies_tmdb_id_check CHECK (tmdb_id >= 0),
+ADD
+    CONSTRAINT movies_releases_check CHECK (jsonb_typeof(releases) = 'array');
+
+-- Empty external IDs mean that the movie doesn't have one, so they're left out of the
+-- unique indexes.
+CREATE UNIQUE INDEX IF NOT EXISTS movies_imdb_id_idx ON movies (imdb_id) WHERE imdb_id <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS movies_tmdb_id_idx ON movies (tmdb_id) WHERE tmdb_id <> 0;
+
+-- CHECK constraints are meant to be immutable, but the old year check depended on the
+-- current date, so PostgreSQL could re-check existing rows against a different year
+-- (when restoring a dump, for example) than the one they were written in. The lower
+-- bound stays as a constraint, and the upper bound is checked by a trigger when the
+-- year is written.
+ALTER TABLE
+    movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE
+    movies
+ADD
+    CONSTRAINT movies_year_check CHECK (year >= 1888);
+
+CREATE OR REPLACE FUNCTION check_movie_year() RETURNS trigger AS $$
+BEGIN
+    IF NEW.year > date_part('year', now()) THEN
+        RAISE EXCEPTION 'movie year % is in the future', NEW.year
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'movies_year_future_check';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_check_year
+BEFORE INSERT OR UPDATE OF year ON movies
+FOR EACH ROW EXECUTE FUNCTION check_movie_year();
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code = 'movies:merge';
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Add the permission for merging duplicate movies.
+INSERT INTO
+    permissions (code)
+VALUES
+    ('movies:merge');
\ No newline at end of file
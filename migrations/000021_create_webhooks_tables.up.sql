@@ -0,0 +1,50 @@
+-- movie_events is an outbox of changes to the movies. Each event is written in the same
+-- transaction as the change and its revision, so an event is recorded if and only if
+-- the change is saved. There's no foreign key on movie_id, so the events outlive the
+-- movies when they're purged from the trash.
+CREATE TABLE IF NOT EXISTS movie_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    type text NOT NULL,
+    snapshot jsonb NOT NULL,
+    changes jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_movie_id_idx ON movie_events (movie_id);
+
+-- An empty events array subscribes the webhook to every type of event.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text [] NOT NULL DEFAULT '{}',
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- A delivery is created for each active webhook when an event is recorded, and is
+-- retried with backoff until it succeeds or runs out of attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id bigint NOT NULL REFERENCES movie_events ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    response_status integer NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- Add the permission for managing webhooks.
+INSERT INTO
+    permissions (code)
+VALUES
+    ('webhooks:manage');
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- Every write to a movie records an event in movie_events in the same transaction, so
+-- notifying on the events (rather than on the movies themselves) means that listeners
+-- hear about each change once it's committed, along with the event ID to fetch it by.
+-- Notifications are only delivered when the transaction commits.
+CREATE OR REPLACE FUNCTION notify_movie_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('movie_events', NEW.id::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movie_events_notify
+AFTER INSERT ON movie_events
+FOR EACH ROW EXECUTE FUNCTION notify_movie_event();
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- Record the ID of the transaction which wrote each movie event. The event IDs come
+-- from a sequence, which hands them out as events are inserted rather than as they're
+-- committed, so a reader resuming after an ID could miss an event with a lower ID which
+-- was committed afterwards. Readers order the events by transaction ID instead, and only
+-- read those from transactions older than any still in flight.
+ALTER TABLE
+    movie_events
+ADD
+    COLUMN IF NOT EXISTS txid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS movie_events_txid_idx ON movie_events (txid, id);
\ No newline at end of file
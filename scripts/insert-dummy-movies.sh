@@ -1,7 +1,7 @@
 #!/bin/bash
 
 # Define the API endpoint
-url="http://localhost:4000/v1/movies"
+url="http://localhost:4000/v1/movies/bulk"
 
 # Define the users to insert
 movies=(
@@ -14,10 +14,9 @@ movies=(
     '{"title":"Frozen","year":2013,"runtime":"102 mins","genres":["animation","adventure","kids"]}'
 )
 
-# Loop through each user and insert them using curl
-for movie in "${movies[@]}"; do
-    curl -X POST "$url" \
+# Join the movies into a JSON array and insert them all with a single request
+body="[$(IFS=,; echo "${movies[*]}")]"
+
+curl -X POST "$url" \
     -H "Content-Type: application/json" \
-    -d "$movie"
-    # echo -e "Movie inserted: $movie\n"
-done
\ No newline at end of file
+    -d "$body"
\ No newline at end of file